@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/kogxi/stub-server/internal/archive"
+	"github.com/kogxi/stub-server/internal/handler"
+)
+
+// archiveOptions returns the handler options loading those of the stub and
+// proto paths that name a zip or tar archive from the archive. The returned
+// closer releases the archives once the handler is created.
+func archiveOptions(httpStubDir string, protoDir string, protoStubDir string) ([]handler.Option, io.Closer, error) {
+	var opts []handler.Option
+	var closers archiveClosers
+	paths := []struct {
+		path   string
+		option func(fs.FS) handler.Option
+	}{
+		{httpStubDir, handler.WithHTTPStubFS},
+		{protoDir, handler.WithProtoFS},
+		{protoStubDir, handler.WithGRPCStubFS},
+	}
+	for _, p := range paths {
+		fsys, err := closers.open(p.path)
+		if err != nil {
+			_ = closers.Close()
+			return nil, nil, err
+		}
+		if fsys != nil {
+			opts = append(opts, p.option(fsys))
+		}
+	}
+	return opts, closers, nil
+}
+
+// archiveClosers closes the opened archives.
+type archiveClosers []io.Closer
+
+// open opens path and adds its closer to c if it names a zip or tar archive.
+// It returns nil otherwise.
+func (c *archiveClosers) open(path string) (fs.FS, error) {
+	if !archive.Is(path) {
+		return nil, nil
+	}
+	fsys, closer, err := archive.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	*c = append(*c, closer)
+	return fsys, nil
+}
+
+func (c archiveClosers) Close() error {
+	var errs []error
+	for _, closer := range c {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
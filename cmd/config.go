@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/kogxi/stub-server/internal/httpstub"
+	"github.com/kogxi/stub-server/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultShutdownTimeout is the default time to wait for requests in flight
+// on shutdown.
+const defaultShutdownTimeout = 30 * time.Second
+
+// envPrefix is the prefix of the environment variables overriding the flags,
+// e.g. STUB_SERVER_ADDRESS for --address.
+const envPrefix = "STUB_SERVER_"
+
+// config is the configuration of the server. It is read from the YAML or
+// JSON file given with --config; environment variables and flags take
+// precedence over it, in this order.
+type config struct {
+	// Address and TLS configure the main listener, which serves all services
+	// not served by one of the Listeners.
+	Address   string           `json:"address"`
+	TLS       tlsConfig        `json:"tls"`
+	Listeners []listenerConfig `json:"listeners"`
+	// SocketMode sets the permissions of Unix domain sockets.
+	SocketMode fileMode `json:"socket_mode"`
+	// ShutdownTimeout is the time to wait for requests in flight on shutdown.
+	ShutdownTimeout duration       `json:"shutdown_timeout"`
+	Proto           protoConfig    `json:"proto"`
+	Stubs           stubsConfig    `json:"stubs"`
+	Admin           adminConfig    `json:"admin"`
+	Metrics         metricsConfig  `json:"metrics"`
+	Tracing         tracingConfig  `json:"tracing"`
+	Log             logConfig      `json:"log"`
+	Defaults        defaultsConfig `json:"defaults"`
+}
+
+type tlsConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+type stubsConfig struct {
+	GRPC string `json:"grpc"`
+	HTTP string `json:"http"`
+}
+
+type adminConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+type metricsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// tracingConfig enables tracing if Endpoint is set.
+type tracingConfig struct {
+	// Endpoint is the URL of the OTLP/gRPC collector, e.g.
+	// "http://localhost:4317".
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"service_name"`
+}
+
+type logConfig struct {
+	// Level is one of "debug", "info", "warn" or "error".
+	Level string `json:"level"`
+	// Format is "text" or "json".
+	Format string          `json:"format"`
+	Access accessLogConfig `json:"access"`
+}
+
+// accessLogConfig enables the access log of the served requests.
+type accessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// BodyLimit is the number of bytes logged of each body, 0 leaves them
+	// out. It defaults to accesslog.DefaultBodyLimit.
+	BodyLimit *int `json:"body_limit"`
+	// RedactHeaders replaces accesslog.DefaultRedactedHeaders.
+	RedactHeaders []string `json:"redact_headers"`
+}
+
+// defaultsConfig applies to all stubs.
+type defaultsConfig struct {
+	// Delay delays every response of a matched stub.
+	Delay     duration        `json:"delay"`
+	Unmatched unmatchedConfig `json:"unmatched"`
+	// EchoHeaders are the request headers copied into every response, e.g.
+	// "traceparent" or "x-b3-*".
+	EchoHeaders []string `json:"echo_headers"`
+}
+
+// unmatchedConfig sets the response to requests no stub matches.
+type unmatchedConfig struct {
+	HTTPStatus int         `json:"http_status"`
+	GRPCCode   *codes.Code `json:"grpc_code"`
+}
+
+// duration is a time.Duration written like "250ms" in config files.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf(`duration must be a string like "250ms": %w`, err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// readConfig decodes the config file at path. YAML is a superset of JSON, so
+// both formats are decoded as YAML and then mapped onto the json tags of
+// config. Unknown fields are rejected to catch typos.
+func readConfig(path string) (config, error) {
+	var cfg config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return cfg, fmt.Errorf("decode config %v: %w", path, err)
+	}
+	if raw == nil {
+		return cfg, nil
+	}
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return cfg, fmt.Errorf("decode config %v: %w", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("decode config %v: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadConfig reads the config file named by the --config flag, if any, and
+// overrides it with the flags of fs set on the command line or through their
+// environment variable.
+func loadConfig(fs *flag.FlagSet) (config, error) {
+	set, err := applyEnv(fs)
+	if err != nil {
+		return config{}, err
+	}
+
+	var cfg config
+	if *configFile != "" {
+		if cfg, err = readConfig(*configFile); err != nil {
+			return cfg, err
+		}
+	}
+
+	override := func(name string, apply func()) {
+		if set[name] {
+			apply()
+		}
+	}
+	override("address", func() { cfg.Address = *address })
+	override("cert", func() { cfg.TLS.Cert = *tlsCert })
+	override("key", func() { cfg.TLS.Key = *tlsCertKey })
+	override("stubs", func() { cfg.Stubs.GRPC = *protoStubDir })
+	override("http", func() { cfg.Stubs.HTTP = *httpStubDir })
+	override("admin", func() { cfg.Admin.Enabled = *admin })
+	override("metrics", func() { cfg.Metrics.Enabled = *enableMetrics })
+	override("echo-headers", func() { cfg.Defaults.EchoHeaders = splitList(*echoHeaders) })
+	override("log-level", func() { cfg.Log.Level = *logLevel })
+	override("log-format", func() { cfg.Log.Format = *logFormat })
+	override("access-log", func() { cfg.Log.Access.Enabled = *accessLog })
+	override("access-log-body-limit", func() { cfg.Log.Access.BodyLimit = accessLogBodyLimit })
+	override("access-log-redact", func() { cfg.Log.Access.RedactHeaders = splitList(*accessLogRedact) })
+	override("tracing-endpoint", func() { cfg.Tracing.Endpoint = *tracingEndpoint })
+	override("tracing-service-name", func() { cfg.Tracing.ServiceName = *tracingServiceName })
+	override("socket-mode", func() { cfg.SocketMode = socketMode })
+	override("shutdown-timeout", func() { cfg.ShutdownTimeout = duration(*shutdownTimeout) })
+	override("proto", func() { cfg.Proto.Dir = protos.Dir })
+	override("proto-path", func() { cfg.Proto.ImportPaths = protos.ImportPaths })
+	override("descriptor-set", func() { cfg.Proto.DescriptorSets = protos.DescriptorSets })
+	override("reflect", func() { cfg.Proto.Reflect = protos.Reflect })
+	override("reflect-cache", func() { cfg.Proto.ReflectCache = protos.ReflectCache })
+
+	// The listeners of the flags use the TLS settings of the main listener.
+	addListener := func(addr string, service string) {
+		cfg.Listeners = append(cfg.Listeners, listenerConfig{Address: addr, Serve: []string{service}, TLS: cfg.TLS})
+	}
+	override("http-address", func() { addListener(*httpAddress, "http") })
+	override("grpc-address", func() { addListener(*grpcAddress, "grpc") })
+	override("admin-address", func() { addListener(*adminAddress, "admin") })
+	override("metrics-address", func() { addListener(*metricsAddress, "metrics") })
+	if cfg.serves("admin") {
+		cfg.Admin.Enabled = true
+	}
+	if cfg.serves("metrics") {
+		cfg.Metrics.Enabled = true
+	}
+
+	if cfg.Address == "" {
+		cfg.Address = *address
+	}
+	if cfg.Tracing.ServiceName == "" {
+		cfg.Tracing.ServiceName = *tracingServiceName
+	}
+	if limit := cfg.Log.Access.BodyLimit; limit != nil && *limit < 0 {
+		return cfg, fmt.Errorf("access log body limit must not be negative, got %d", *limit)
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the flags of fs that weren't given on the command line from
+// their environment variables and returns the names of all flags set either
+// way.
+func applyEnv(fs *flag.FlagSet) (map[string]bool, error) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %v: %w", v, name, err))
+			return
+		}
+		set[f.Name] = true
+	})
+	return set, errors.Join(errs...)
+}
+
+// splitList splits the comma-separated list s, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// drainTimeout returns the time to wait for requests in flight on shutdown.
+func (cfg config) drainTimeout() time.Duration {
+	if cfg.ShutdownTimeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(cfg.ShutdownTimeout)
+}
+
+// handlerOptions returns the options of the handler described by cfg. The
+// access log, if enabled, is written to accessLogger or the default logger if
+// it is nil.
+func (cfg config) handlerOptions(accessLogger *slog.Logger) []handler.Option {
+	grpcOpts := cfg.Proto.options()
+	var httpOpts []httpstub.Option
+	if d := time.Duration(cfg.Defaults.Delay); d > 0 {
+		grpcOpts = append(grpcOpts, grpcstub.WithDelay(d))
+		httpOpts = append(httpOpts, httpstub.WithDelay(d))
+	}
+	if code := cfg.Defaults.Unmatched.GRPCCode; code != nil {
+		grpcOpts = append(grpcOpts, grpcstub.WithUnmatchedCode(*code))
+	}
+	if status := cfg.Defaults.Unmatched.HTTPStatus; status != 0 {
+		httpOpts = append(httpOpts, httpstub.WithUnmatchedStatus(status))
+	}
+	if echo := cfg.Defaults.EchoHeaders; len(echo) > 0 {
+		grpcOpts = append(grpcOpts, grpcstub.WithEchoHeaders(echo...))
+		httpOpts = append(httpOpts, httpstub.WithEchoHeaders(echo...))
+	}
+
+	opts := []handler.Option{
+		handler.WithGRPCOptions(grpcOpts...),
+		handler.WithHTTPOptions(httpOpts...),
+	}
+	if cfg.Admin.Enabled {
+		opts = append(opts, handler.WithAdmin())
+	}
+	if cfg.Metrics.Enabled {
+		opts = append(opts, handler.WithMetrics())
+	}
+	if access := cfg.Log.Access; access.Enabled {
+		var logOpts []accesslog.Option
+		if accessLogger != nil {
+			logOpts = append(logOpts, accesslog.WithLogger(accessLogger))
+		}
+		if access.BodyLimit != nil {
+			logOpts = append(logOpts, accesslog.WithBodyLimit(*access.BodyLimit))
+		}
+		if access.RedactHeaders != nil {
+			logOpts = append(logOpts, accesslog.WithRedactedHeaders(access.RedactHeaders...))
+		}
+		opts = append(opts, handler.WithAccessLog(logOpts...))
+	}
+	return opts
+}
+
+// provider returns the tracer provider exporting to the configured endpoint,
+// or nil if tracing is disabled.
+func (t tracingConfig) provider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if t.Endpoint == "" {
+		return nil, nil
+	}
+	return tracing.NewProvider(ctx, t.Endpoint, t.ServiceName)
+}
+
+// logger returns the logger described by l, writing to w. It returns nil if
+// neither the level nor the format is set, keeping the default logger.
+func (l logConfig) logger(w io.Writer) (*slog.Logger, error) {
+	if l.Level == "" && l.Format == "" {
+		return nil, nil
+	}
+
+	var level slog.Level
+	if l.Level != "" {
+		if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+			return nil, fmt.Errorf("log level: %w", err)
+		}
+	}
+	return l.newLogger(w, level)
+}
+
+// accessLogger returns the logger of the access log, writing to w in the
+// configured format. It logs at the info level whatever the configured level,
+// so that raising the level quiets the other logs but not the access log. It
+// returns nil if the level isn't set, keeping the default logger.
+func (l logConfig) accessLogger(w io.Writer) (*slog.Logger, error) {
+	if l.Level == "" {
+		return nil, nil
+	}
+	return l.newLogger(w, slog.LevelInfo)
+}
+
+func (l logConfig) newLogger(w io.Writer, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch l.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf(`log format must be "text" or "json", got %q`, l.Format)
+}
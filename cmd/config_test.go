@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// writeFile writes data to the file name in a temporary directory and
+// returns its path.
+func writeFile(t *testing.T, name string, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+// parseFlags resets the flags of the server to their defaults and parses args
+// with a new flag set sharing their values, so that only the flags in args
+// count as set.
+func parseFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+
+	protos = protoConfig{}
+	fs := flag.NewFlagSet("stub-server", flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		if _, ok := f.Value.(*stringList); !ok {
+			require.NoError(t, f.Value.Set(f.DefValue))
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	require.NoError(t, fs.Parse(args))
+	return fs
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		want    config
+		wantErr string
+	}{
+		{
+			name: "YAML",
+			file: "config.yaml",
+			data: "address: :8080\nshutdown_timeout: 5s\nstubs:\n  http: ./http\nproto:\n  import_paths: [a, b]\ndefaults:\n  delay: 250ms\n  unmatched:\n    grpc_code: PERMISSION_DENIED\n",
+			want: config{
+				Address:         ":8080",
+				ShutdownTimeout: duration(5 * time.Second),
+				Stubs:           stubsConfig{HTTP: "./http"},
+				Proto:           protoConfig{ImportPaths: []string{"a", "b"}},
+				Defaults: defaultsConfig{
+					Delay:     duration(250 * time.Millisecond),
+					Unmatched: unmatchedConfig{GRPCCode: ptr(codes.PermissionDenied)},
+				},
+			},
+		},
+		{
+			name: "JSON",
+			file: "config.json",
+			data: `{"address": ":8080", "admin": {"enabled": true}, "defaults": {"unmatched": {"http_status": 418}}}`,
+			want: config{
+				Address:  ":8080",
+				Admin:    adminConfig{Enabled: true},
+				Defaults: defaultsConfig{Unmatched: unmatchedConfig{HTTPStatus: 418}},
+			},
+		},
+		{
+			name: "Empty",
+			file: "config.yaml",
+			data: "# nothing configured\n",
+		},
+		{
+			name:    "Unknown field",
+			file:    "config.yaml",
+			data:    "adress: :8080\n",
+			wantErr: `unknown field "adress"`,
+		},
+		{
+			name:    "Invalid duration",
+			file:    "config.json",
+			data:    `{"shutdown_timeout": 5}`,
+			wantErr: "duration must be a string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := readConfig(writeFile(t, tt.file, tt.data))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cfg)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := writeFile(t, "config.yaml", "address: :1000\nstubs:\n  http: ./file\nmetrics:\n  enabled: false\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		check   func(t *testing.T, cfg config)
+		wantErr string
+	}{
+		{
+			name: "Defaults",
+			check: func(t *testing.T, cfg config) {
+				assert.Equal(t, ":50051", cfg.Address)
+				assert.Equal(t, defaultShutdownTimeout, cfg.drainTimeout())
+			},
+		},
+		{
+			name: "File",
+			args: []string{"--config", file},
+			check: func(t *testing.T, cfg config) {
+				assert.Equal(t, ":1000", cfg.Address)
+				assert.Equal(t, "./file", cfg.Stubs.HTTP)
+			},
+		},
+		{
+			name: "Environment over file",
+			env:  map[string]string{"STUB_SERVER_ADDRESS": ":2000", "STUB_SERVER_METRICS": "true", "STUB_SERVER_PROTO_PATH": "./env"},
+			args: []string{"--config", file},
+			check: func(t *testing.T, cfg config) {
+				assert.Equal(t, ":2000", cfg.Address)
+				assert.True(t, cfg.Metrics.Enabled)
+				assert.Equal(t, []string{"./env"}, cfg.Proto.ImportPaths)
+				assert.Equal(t, "./file", cfg.Stubs.HTTP)
+			},
+		},
+		{
+			name: "Flags over environment",
+			env:  map[string]string{"STUB_SERVER_ADDRESS": ":2000", "STUB_SERVER_HTTP": "./env"},
+			args: []string{"--config", file, "--address", ":3000"},
+			check: func(t *testing.T, cfg config) {
+				assert.Equal(t, ":3000", cfg.Address)
+				assert.Equal(t, "./env", cfg.Stubs.HTTP)
+			},
+		},
+		{
+			name:    "Invalid environment variable",
+			env:     map[string]string{"STUB_SERVER_SHUTDOWN_TIMEOUT": "soon"},
+			wantErr: "STUB_SERVER_SHUTDOWN_TIMEOUT",
+		},
+		{
+			name:    "Missing file",
+			args:    []string{"--config", "missing.yaml"},
+			wantErr: "read config",
+		},
+		{
+			name:    "Negative access log body limit",
+			args:    []string{"--access-log", "--access-log-body-limit", "-1"},
+			wantErr: "access log body limit must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := loadConfig(parseFlags(t, tt.args...))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	cfg := config{
+		Defaults: defaultsConfig{
+			Delay:     duration(delay),
+			Unmatched: unmatchedConfig{HTTPStatus: http.StatusTeapot, GRPCCode: ptr(codes.PermissionDenied)},
+		},
+	}
+	matched := serveConfig(t, cfg, "../examples/protostubs")
+	// Without stubs, the admin API keeps the gRPC stub server.
+	cfg.Admin.Enabled = true
+	unmatched := serveConfig(t, cfg, "")
+
+	start := time.Now()
+	resp, err := http.Get(matched + "/helloworld")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(t, time.Since(start), delay)
+
+	resp, err = http.Get(unmatched + "/nope")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	start = time.Now()
+	_, err = greeter(t, matched).SayHello(context.Background(), &helloworldpb.HelloRequest{Name: "Jane"})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), delay)
+
+	_, err = greeter(t, unmatched).SayHello(context.Background(), &helloworldpb.HelloRequest{Name: "Jane"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// serveConfig serves the example stubs with the handler options of cfg and
+// returns the URL of the server.
+func serveConfig(t *testing.T, cfg config, protoStubDir string) string {
+	t.Helper()
+
+	h, err := handler.New("../examples/httpstubs", "../examples/protos", protoStubDir, cfg.handlerOptions(nil)...)
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func greeter(t *testing.T, url string) helloworldpb.GreeterClient {
+	t.Helper()
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(url, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return helloworldpb.NewGreeterClient(conn)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
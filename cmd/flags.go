@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+)
+
+// stringList is a flag that can be repeated, collecting all values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// protoConfig selects where the proto definitions are loaded from. Its flags
+// are shared by all commands, the server also reads it from the config file.
+type protoConfig struct {
+	Dir            string   `json:"dir"`
+	ImportPaths    []string `json:"import_paths"`
+	DescriptorSets []string `json:"descriptor_sets"`
+	Reflect        string   `json:"reflect"`
+	ReflectCache   string   `json:"reflect_cache"`
+}
+
+func (p *protoConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.Dir, "proto", "", "Path to proto files, a directory or a zip or tar archive")
+	fs.Var((*stringList)(&p.ImportPaths), "proto-path", "Additional directory to resolve proto imports from, can be repeated")
+	fs.Var((*stringList)(&p.DescriptorSets), "descriptor-set", "Path to a binary or JSON FileDescriptorSet, can be repeated")
+	fs.StringVar(&p.Reflect, "reflect", "", "Address of a gRPC server to load the proto definitions from via reflection")
+	fs.StringVar(&p.ReflectCache, "reflect-cache", "", "Path to cache the definitions loaded via reflection in")
+}
+
+// isSet reports whether any source of proto definitions is configured.
+func (p *protoConfig) isSet() bool {
+	return p.Dir != "" || len(p.DescriptorSets) > 0 || p.Reflect != ""
+}
+
+func (p *protoConfig) options() []grpcstub.Option {
+	opts := []grpcstub.Option{
+		grpcstub.WithImportPaths(p.ImportPaths...),
+		grpcstub.WithDescriptorSets(p.DescriptorSets...),
+	}
+	if p.Reflect != "" {
+		opts = append(opts, grpcstub.WithReflection(p.Reflect, p.ReflectCache))
+	}
+	return opts
+}
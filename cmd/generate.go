@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+)
+
+// generate implements the "generate" command. It writes a skeleton gRPC stub
+// for every method of the loaded proto files and prints the paths of the
+// written files to w. It returns the exit code of the command.
+func generate(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	var protos protoConfig
+	protos.register(fs)
+	outDir := fs.String("out", "", "Directory to write the gRPC stubs to")
+	force := fs.Bool("force", false, "Overwrite existing stub files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if !protos.isSet() || *outDir == "" {
+		_, _ = fmt.Fprintln(w, "generate: --out and one of --proto, --descriptor-set or --reflect are required")
+		return 2
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	var archives archiveClosers
+	defer func() { _ = archives.Close() }()
+	opts := protos.options()
+	protoFS, err := archives.open(protos.Dir)
+	if err != nil {
+		_, _ = fmt.Fprintln(w, err)
+		return 1
+	}
+	if protoFS != nil {
+		opts = append(opts, grpcstub.WithProtoFS(protoFS))
+	}
+
+	written, err := grpcstub.Generate(protos.Dir, *outDir, *force, opts...)
+	for _, path := range written {
+		_, _ = fmt.Fprintln(w, path)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(w, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	tests := []struct {
+		name     string
+		proto    string
+		wantCode int
+		wantOut  string
+	}{
+		{name: "Directory", proto: "../examples/protos", wantOut: "helloworld.Greeter.SayHello.json"},
+		{name: "Archive", proto: zipDir(t, "../examples/protos"), wantOut: "helloworld.Greeter.SayHello.json"},
+		{name: "Missing archive", proto: "missing.zip", wantCode: 1, wantOut: "missing.zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := generate([]string{"--proto", tt.proto, "--out", filepath.Join(t.TempDir(), "stubs")}, &out)
+			assert.Equal(t, tt.wantCode, code, out.String())
+			assert.Contains(t, out.String(), tt.wantOut)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/handler"
+)
+
+// services are the names of the services a listener can serve.
+var services = map[string]handler.Service{
+	"http":    handler.ServiceHTTP,
+	"grpc":    handler.ServiceGRPC,
+	"admin":   handler.ServiceAdmin,
+	"metrics": handler.ServiceMetrics,
+}
+
+// listenerConfig is an additional listener serving some of the services
+// separately from the main address.
+type listenerConfig struct {
+	Address string `json:"address"`
+	// Serve lists the services of the listener: "http", "grpc", "admin" and
+	// "metrics".
+	Serve []string  `json:"serve"`
+	TLS   tlsConfig `json:"tls"`
+	// SocketMode overrides the permissions of the config for a Unix domain
+	// socket.
+	SocketMode *fileMode `json:"socket_mode"`
+}
+
+// listener is a resolved listenerConfig.
+type listener struct {
+	address    string
+	services   handler.Service
+	tls        tlsConfig
+	socketMode fileMode
+}
+
+// fileMode is an os.FileMode written in octal like "0660".
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(v string) error {
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode %q, must be octal like 0660", v)
+	}
+	*m = fileMode(mode)
+	return nil
+}
+
+func (m *fileMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf(`file mode must be a string like "0660": %w`, err)
+	}
+	return m.Set(s)
+}
+
+// listeners returns the listeners of cfg. The main address serves all
+// services that no additional listener serves and is left out if there are
+// none.
+func (cfg config) listeners() ([]listener, error) {
+	var claimed handler.Service
+	ls := make([]listener, 0, len(cfg.Listeners)+1)
+	for i, l := range cfg.Listeners {
+		if l.Address == "" {
+			return nil, fmt.Errorf("listener %d: address is required", i)
+		}
+		if len(l.Serve) == 0 {
+			return nil, fmt.Errorf("listener %v: serve is required", l.Address)
+		}
+
+		var svc handler.Service
+		for _, name := range l.Serve {
+			s, ok := services[name]
+			if !ok {
+				return nil, fmt.Errorf(`listener %v: unknown service %q, must be "http", "grpc", "admin" or "metrics"`, l.Address, name)
+			}
+			svc |= s
+		}
+		claimed |= svc
+
+		mode := cfg.SocketMode
+		if l.SocketMode != nil {
+			mode = *l.SocketMode
+		}
+		ls = append(ls, listener{address: l.Address, services: svc, tls: l.TLS, socketMode: mode})
+	}
+
+	if rest := handler.ServiceAll &^ claimed; rest != 0 {
+		ls = append([]listener{{address: cfg.Address, services: rest, tls: cfg.TLS, socketMode: cfg.SocketMode}}, ls...)
+	}
+	return ls, nil
+}
+
+// serves reports whether one of the additional listeners serves the service
+// with name.
+func (cfg config) serves(name string) bool {
+	for _, l := range cfg.Listeners {
+		if slices.Contains(l.Serve, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// listen announces on addr, which is one of
+//
+//	:50051                   a TCP address
+//	unix:///tmp/stub.sock    a Unix domain socket with an absolute path
+//	unix:stub.sock           a Unix domain socket with a relative path
+//	unix-abstract:stub       a Unix domain socket in the abstract namespace (Linux only)
+//
+// like the gRPC name syntax. The socket file of a Unix domain socket gets the
+// permissions of mode, if set, and is removed when the listener is closed. A
+// stale socket file left behind by a server that didn't exit cleanly is
+// replaced.
+func listen(addr string, mode fileMode) (net.Listener, error) {
+	var path string
+	switch {
+	case strings.HasPrefix(addr, "unix-abstract:"):
+		// A leading "@" selects the abstract namespace, which has no file.
+		lis, err := net.Listen("unix", "@"+strings.TrimPrefix(addr, "unix-abstract:"))
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		return lis, nil
+	case strings.HasPrefix(addr, "unix://"):
+		path = strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "unix:"):
+		path = strings.TrimPrefix(addr, "unix:")
+	default:
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		return lis, nil
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		lis, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %w", err)
+		}
+		return lis, nil
+	}
+	return listenWithMode(path, os.FileMode(mode))
+}
+
+// listenWithMode announces on a Unix domain socket at path with the
+// permissions of mode. The socket is created in a directory only the server
+// can access and linked to path once it has its permissions, so that it is
+// never reachable with the permissions of the umask.
+func listenWithMode(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	tmp := filepath.Join(dir, "s")
+	lis, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	// The temporary name is removed with dir.
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		_ = lis.Close()
+		return nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+	// Unlike a rename, a link doesn't replace an existing file at path.
+	if err := os.Link(tmp, path); err != nil {
+		_ = lis.Close()
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	return &socketListener{Listener: lis, path: path}, nil
+}
+
+// socketListener removes the socket file at path when it is closed.
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l *socketListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.path); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+		err = errors.Join(err, rmErr)
+	}
+	return err
+}
+
+// removeStaleSocket removes the socket file at path if no server accepts
+// connections on it anymore. Other files are left alone, so that listening
+// on them fails.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != os.ModeSocket {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("listen: %v is in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name        string
+		addr        string
+		wantNetwork string
+		wantAddr    string
+	}{
+		{name: "TCP", addr: "127.0.0.1:0", wantNetwork: "tcp"},
+		{name: "Absolute path", addr: "unix://" + filepath.Join(dir, "abs.sock"), wantNetwork: "unix", wantAddr: filepath.Join(dir, "abs.sock")},
+		{name: "Relative path", addr: "unix:rel.sock", wantNetwork: "unix", wantAddr: "rel.sock"},
+		{name: "Abstract", addr: "unix-abstract:stub-server-test", wantNetwork: "unix", wantAddr: "@stub-server-test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(dir)
+
+			lis, err := listen(tt.addr, 0)
+			require.NoError(t, err)
+			defer lis.Close()
+			assert.Equal(t, tt.wantNetwork, lis.Addr().Network())
+			if tt.wantAddr != "" {
+				assert.Equal(t, tt.wantAddr, lis.Addr().String())
+			}
+
+			conn, err := net.Dial(lis.Addr().Network(), lis.Addr().String())
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+		})
+	}
+}
+
+func TestListenSocketFile(t *testing.T) {
+	t.Run("Permissions", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stub.sock")
+		lis, err := listen("unix://"+path, 0o600)
+		require.NoError(t, err)
+		defer lis.Close()
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+		// Only the socket is left in the directory.
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+		require.NoError(t, lis.Close())
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("Socket in use", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stub.sock")
+		lis, err := listen("unix://"+path, 0)
+		require.NoError(t, err)
+		defer lis.Close()
+
+		_, err = listen("unix://"+path, 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "in use")
+	})
+
+	t.Run("Stale socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stub.sock")
+		stale, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		// Leave the socket file behind like a server that crashed.
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, stale.Close())
+		require.FileExists(t, path)
+
+		lis, err := listen("unix://"+path, 0)
+		require.NoError(t, err)
+		defer lis.Close()
+		conn, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	})
+
+	t.Run("Regular file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stub.sock")
+		require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+
+		_, err := listen("unix://"+path, 0)
+		require.Error(t, err)
+		_, err = listen("unix://"+path, 0o600)
+		require.Error(t, err)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+	})
+}
+
+func TestFileMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    fileMode
+		wantErr bool
+	}{
+		{value: "0660", want: 0o660},
+		{value: "600", want: 0o600},
+		{value: "0777", want: 0o777},
+		{value: "1777", wantErr: true},
+		{value: "0999", wantErr: true},
+		{value: "rw-rw----", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var m fileMode
+			err := m.Set(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m)
+
+			var fromJSON fileMode
+			require.NoError(t, json.Unmarshal([]byte(`"`+tt.value+`"`), &fromJSON))
+			assert.Equal(t, tt.want, fromJSON)
+		})
+	}
+
+	var m fileMode
+	require.Error(t, json.Unmarshal([]byte(`660`), &m), "file modes must be strings")
+}
@@ -4,66 +4,204 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/kogxi/stub-server/internal/accesslog"
 	"github.com/kogxi/stub-server/internal/handler"
 	"golang.org/x/sync/errgroup"
 )
 
 var (
-	address      = flag.String("address", ":50051", "Port to listen on")
-	protoDir     = flag.String("proto", "", "Path to proto files")
-	protoStubDir = flag.String("stubs", "", "Path to gRPC stubs")
-	httpStubDir  = flag.String("http", "", "Path to HTTP stubs")
-	tlsCert      = flag.String("cert", "", "Path to TLS certificate")
-	tlsCertKey   = flag.String("key", "", "Path to TLS certificate key")
+	address            = flag.String("address", ":50051", "Address to listen on, a TCP address or unix:///path/to/socket")
+	httpAddress        = flag.String("http-address", "", "Separate address to serve the HTTP stubs on")
+	grpcAddress        = flag.String("grpc-address", "", "Separate address to serve the gRPC stubs on")
+	adminAddress       = flag.String("admin-address", "", "Separate address to serve the admin API on, enables it")
+	metricsAddress     = flag.String("metrics-address", "", "Separate address to serve the metrics on, enables them")
+	protoStubDir       = flag.String("stubs", "", "Path to gRPC stubs, a directory or a zip or tar archive")
+	httpStubDir        = flag.String("http", "", "Path to HTTP stubs, a directory or a zip or tar archive")
+	tlsCert            = flag.String("cert", "", "Path to TLS certificate")
+	tlsCertKey         = flag.String("key", "", "Path to TLS certificate key")
+	admin              = flag.Bool("admin", false, "Enable the admin API under /__admin/ to manage stubs at runtime")
+	enableMetrics      = flag.Bool("metrics", false, "Serve Prometheus metrics of the served requests under /metrics")
+	logLevel           = flag.String("log-level", "", "Log level: debug, info, warn or error (default info)")
+	logFormat          = flag.String("log-format", "", "Log format: text or json (default text)")
+	accessLog          = flag.Bool("access-log", false, "Log every served request with the headers and bodies of the request and the response")
+	accessLogBodyLimit = flag.Int("access-log-body-limit", accesslog.DefaultBodyLimit, "Bytes logged of each body in the access log, 0 leaves them out")
+	accessLogRedact    = flag.String("access-log-redact", strings.Join(accesslog.DefaultRedactedHeaders, ","), "Comma-separated headers whose values are redacted in the access log, a trailing * matches a prefix")
+	echoHeaders        = flag.String("echo-headers", "", "Comma-separated request headers to copy into every response, e.g. traceparent,x-request-id,x-b3-*")
+	tracingEndpoint    = flag.String("tracing-endpoint", "", "URL of an OTLP/gRPC collector to export traces of the served requests to, e.g. http://localhost:4317")
+	tracingServiceName = flag.String("tracing-service-name", "stub-server", "Service name of the exported traces")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", defaultShutdownTimeout, "Time to wait for requests in flight, including gRPC streams, on shutdown")
+	configFile         = flag.String("config", "", "Path to a YAML or JSON config file, overridden by flags and STUB_SERVER_* environment variables")
+	protos             protoConfig
+	socketMode         fileMode
 )
 
+func init() {
+	protos.register(flag.CommandLine)
+	flag.Var(&socketMode, "socket-mode", "Permissions of Unix domain sockets in octal, e.g. 0660")
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:], os.Stderr))
+		case "generate":
+			os.Exit(generate(os.Args[2:], os.Stderr))
+		}
+	}
+
 	flag.Parse()
 
 	ctx := context.Background()
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	handler, err := handler.New(*httpStubDir, *protoDir, *protoStubDir)
+	cfg, err := loadConfig(flag.CommandLine)
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to create handler", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "Failed to load config", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+
+	logger, err := cfg.Log.logger(os.Stderr)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to configure logging", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+	if logger != nil {
+		slog.SetDefault(logger)
+	}
+	accessLogger, err := cfg.Log.accessLogger(os.Stderr)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to configure logging", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+
+	opts := cfg.handlerOptions(accessLogger)
+	tp, err := cfg.Tracing.provider(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to configure tracing", slog.String("error", err.Error()))
+		os.Exit(2)
+	}
+	if tp != nil {
+		opts = append(opts, handler.WithTracing(tp))
+	}
+	archiveOpts, archives, err := archiveOptions(cfg.Stubs.HTTP, cfg.Proto.Dir, cfg.Stubs.GRPC)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to open archive", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	opts = append(opts, archiveOpts...)
 
-	tls, err := loadTLS(*tlsCert, *tlsCertKey)
+	server, err := handler.NewServer(cfg.Stubs.HTTP, cfg.Proto.Dir, cfg.Stubs.GRPC, opts...)
+	_ = archives.Close()
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to load TLS config", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "Failed to create handler", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
-	srv := &http.Server{
-		Addr:      *address,
-		Handler:   handler,
-		TLSConfig: tls,
+	listeners, err := cfg.listeners()
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid listeners", slog.String("error", err.Error()))
+		os.Exit(2)
 	}
 
-	eg := new(errgroup.Group)
-	eg.Go(func() error {
-		slog.Info("Listening", slog.String("address", srv.Addr))
-		return srv.ListenAndServe()
-	})
+	eg, ctx := errgroup.WithContext(ctx)
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		tls, err := loadTLS(l.tls.Cert, l.tls.Key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load TLS config", slog.String("address", l.address), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		lis, err := listen(l.address, l.socketMode)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to listen", slog.String("address", l.address), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		srv, err := server.NewHTTPServer(l.services, tls)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create server", slog.String("address", l.address), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		servers = append(servers, srv)
+
+		eg.Go(func() error {
+			slog.Info("Listening", slog.String("address", l.address), slog.String("services", l.services.String()), slog.Bool("tls", tls != nil))
+			var err error
+			if tls != nil {
+				err = srv.ServeTLS(lis, "", "")
+			} else {
+				err = srv.Serve(lis)
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return fmt.Errorf("serve %v: %w", l.address, err)
+		})
+	}
 
 	eg.Go(func() error {
 		<-ctx.Done()
-		slog.Info("Closing server")
-		return srv.Close()
+		return shutdown(server, servers, cfg.drainTimeout())
 	})
 
 	err = eg.Wait()
+	if tp != nil {
+		// Flush the spans of the last requests.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := tp.Shutdown(ctx); err != nil {
+			slog.Warn("Failed to export traces", slog.String("error", err.Error()))
+		}
+		cancel()
+	}
 	if err != nil {
-		slog.ErrorContext(ctx, "Server stopped", slog.String("error", err.Error()))
+		slog.Error("Server stopped", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
+}
+
+// shutdown stops the servers from accepting new requests and waits up to
+// timeout for the requests in flight to be served, including gRPC streams.
+// HTTP/2 clients are told to stop starting new calls with GOAWAY. Requests
+// still in flight after timeout are aborted.
+func shutdown(server *handler.Server, servers []*http.Server, timeout time.Duration) error {
+	slog.Info("Shutting down", slog.Duration("drain_timeout", timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	eg := new(errgroup.Group)
+	for _, srv := range servers {
+		eg.Go(func() error {
+			return srv.Shutdown(ctx)
+		})
+	}
+	err := eg.Wait()
+	if err == nil {
+		err = server.Shutdown(ctx)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	slog.Warn("Drain timeout exceeded, closing connections", slog.String("error", err.Error()))
+	for _, srv := range servers {
+		_ = srv.Close()
 	}
+	return nil
 }
 
 func loadTLS(certFile string, keyFile string) (*tls.Config, error) {
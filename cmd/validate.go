@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/kogxi/stub-server/internal/httpstub"
+)
+
+// validate implements the "validate" command. It checks the stubs without
+// starting a server and prints every problem found to w, one per line. It
+// returns the exit code of the command.
+func validate(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	var protos protoConfig
+	protos.register(fs)
+	protoStubDir := fs.String("stubs", "", "Path to gRPC stubs, a directory or a zip or tar archive")
+	httpStubDir := fs.String("http", "", "Path to HTTP stubs, a directory or a zip or tar archive")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Only report problems, not the progress of loading the protos.
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	if *protoStubDir != "" && !protos.isSet() {
+		_, _ = fmt.Fprintln(w, "validate: --stubs requires --proto, --descriptor-set or --reflect")
+		return 2
+	}
+
+	var archives archiveClosers
+	defer func() { _ = archives.Close() }()
+
+	var errs []error
+	if *httpStubDir != "" {
+		errs = append(errs, validateHTTP(*httpStubDir, &archives))
+	}
+	if *protoStubDir != "" {
+		errs = append(errs, validateGRPC(protos, *protoStubDir, &archives))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		_, _ = fmt.Fprintln(w, err)
+		return 1
+	}
+	return 0
+}
+
+// validateHTTP checks the HTTP stubs in the directory or archive at path.
+// Opened archives are added to archives.
+func validateHTTP(path string, archives *archiveClosers) error {
+	fsys, err := archives.open(path)
+	if err != nil {
+		return err
+	}
+	if fsys != nil {
+		return httpstub.ValidateFS(fsys)
+	}
+	return httpstub.Validate(path)
+}
+
+// validateGRPC checks the gRPC stubs in the directory or archive at path
+// against the proto definitions of protos, which may be an archive, too.
+func validateGRPC(protos protoConfig, path string, archives *archiveClosers) error {
+	opts := protos.options()
+	protoFS, err := archives.open(protos.Dir)
+	if err != nil {
+		return err
+	}
+	if protoFS != nil {
+		opts = append(opts, grpcstub.WithProtoFS(protoFS))
+	}
+	stubFS, err := archives.open(path)
+	if err != nil {
+		return err
+	}
+	if stubFS != nil {
+		opts = append(opts, grpcstub.WithStubFS(stubFS))
+	}
+	return grpcstub.Validate(protos.Dir, path, opts...)
+}
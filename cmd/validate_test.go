@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipDir writes the files in dir to a zip archive in a temporary directory
+// and returns its path.
+func zipDir(t *testing.T, dir string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), filepath.Base(dir)+".zip")
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	w := zip.NewWriter(f)
+	require.NoError(t, w.AddFS(os.DirFS(dir)))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+	return name
+}
+
+func TestValidateArchives(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantOut  string
+	}{
+		{
+			name: "Directories",
+			args: []string{"--http", "../examples/httpstubs", "--proto", "../examples/protos", "--stubs", "../examples/protostubs"},
+		},
+		{
+			name: "Archives",
+			args: []string{
+				"--http", zipDir(t, "../examples/httpstubs"),
+				"--proto", zipDir(t, "../examples/protos"),
+				"--stubs", zipDir(t, "../examples/protostubs"),
+			},
+		},
+		{
+			name:     "Invalid stubs in archive",
+			args:     []string{"--proto", "../examples/protos", "--stubs", zipDir(t, "../internal/grpcstub/testdata/invalid")},
+			wantCode: 1,
+			wantOut:  `a.yaml:7: stub 1: service "routeguide.RouteGuide" has no method "Nope"`,
+		},
+		{
+			name:     "Missing archive",
+			args:     []string{"--http", "missing.zip"},
+			wantCode: 1,
+			wantOut:  "missing.zip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.Equal(t, tt.wantCode, validate(tt.args, &out), out.String())
+			assert.Contains(t, out.String(), tt.wantOut)
+		})
+	}
+}
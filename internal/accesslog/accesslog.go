@@ -0,0 +1,202 @@
+// Package accesslog logs every exchange served by the stub servers with the
+// headers and bodies of the request and the response, to see what a service
+// under test sent and what it got back.
+package accesslog
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultBodyLimit is the default number of bytes logged of each body.
+const DefaultBodyLimit = 4096
+
+// redacted replaces the values of redacted headers.
+const redacted = "REDACTED"
+
+// DefaultRedactedHeaders are the headers whose values are redacted by default.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Exchange is a request served by one of the stub servers and its response.
+type Exchange struct {
+	// Protocol is journal.ProtocolHTTP or journal.ProtocolGRPC.
+	Protocol string
+	// Service is the service of gRPC calls.
+	Service string
+	// Method is the HTTP method of HTTP requests and the method name of gRPC
+	// calls.
+	Method string
+	// Path is the path of HTTP requests.
+	Path    string
+	Matched bool
+	// Status is the HTTP status code or the name of the gRPC status code.
+	Status string
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+
+	// RequestHeader and ResponseHeader hold the HTTP headers or the gRPC
+	// metadata.
+	RequestHeader  map[string][]string
+	ResponseHeader map[string][]string
+	// RequestBody and ResponseBody hold the HTTP bodies or the gRPC messages
+	// in JSON, one per line. They may hold only the first BodyLimit bytes.
+	RequestBody  []byte
+	ResponseBody []byte
+	// RequestBodySize and ResponseBodySize are the sizes of the whole bodies.
+	// They default to the lengths of RequestBody and ResponseBody.
+	RequestBodySize  int64
+	ResponseBodySize int64
+}
+
+// Logger logs exchanges at the info level. A nil Logger logs nothing.
+type Logger struct {
+	logger    *slog.Logger
+	bodyLimit int
+	redact    []string
+}
+
+// Option configures a Logger created by New.
+type Option func(*Logger)
+
+// WithLogger logs to logger instead of the default slog logger, e.g. to keep
+// logging exchanges when the level of the other logs is above info.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Logger) {
+		l.logger = logger
+	}
+}
+
+// WithBodyLimit logs at most n bytes of each body. Bodies are left out if n is
+// 0 or negative. It defaults to DefaultBodyLimit.
+func WithBodyLimit(n int) Option {
+	return func(l *Logger) {
+		l.bodyLimit = max(n, 0)
+	}
+}
+
+// WithRedactedHeaders redacts the values of the headers matching patterns
+// instead of DefaultRedactedHeaders. A pattern ending in "*" matches all
+// headers starting with the text before it. Matching ignores case.
+func WithRedactedHeaders(patterns ...string) Option {
+	return func(l *Logger) {
+		l.redact = patterns
+	}
+}
+
+// New returns a Logger configured by opts.
+func New(opts ...Option) *Logger {
+	l := &Logger{
+		bodyLimit: DefaultBodyLimit,
+		redact:    DefaultRedactedHeaders,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Enabled reports whether exchanges are logged, so that the bodies are only
+// collected if they are.
+func (l *Logger) Enabled(ctx context.Context) bool {
+	return l != nil && l.output().Enabled(ctx, slog.LevelInfo)
+}
+
+// BodyLimit returns the number of bytes logged of each body, so that no more
+// of them is collected.
+func (l *Logger) BodyLimit() int {
+	if l == nil {
+		return 0
+	}
+	return l.bodyLimit
+}
+
+// output returns the logger exchanges are logged to.
+func (l *Logger) output() *slog.Logger {
+	if l.logger != nil {
+		return l.logger
+	}
+	return slog.Default()
+}
+
+// Log logs e.
+func (l *Logger) Log(ctx context.Context, e Exchange) {
+	if !l.Enabled(ctx) {
+		return
+	}
+
+	attrs := []slog.Attr{slog.String("protocol", e.Protocol)}
+	if e.Service != "" {
+		attrs = append(attrs, slog.String("service", e.Service))
+	}
+	attrs = append(attrs, slog.String("method", e.Method))
+	if e.Path != "" {
+		attrs = append(attrs, slog.String("path", e.Path))
+	}
+	attrs = append(attrs,
+		slog.String("status", e.Status),
+		slog.Bool("matched", e.Matched),
+		slog.Duration("duration", e.Duration),
+		slog.Group("request", l.message(e.RequestHeader, e.RequestBody, e.RequestBodySize)...),
+		slog.Group("response", l.message(e.ResponseHeader, e.ResponseBody, e.ResponseBodySize)...),
+	)
+	l.output().LogAttrs(ctx, slog.LevelInfo, "Served request", attrs...)
+}
+
+// message returns the attributes of a request or response with header and
+// body, which is the start of a body of size bytes.
+func (l *Logger) message(header map[string][]string, body []byte, size int64) []any {
+	attrs := []any{slog.Group("header", l.header(header)...)}
+	size = max(size, int64(len(body)))
+	if l.bodyLimit <= 0 || size == 0 {
+		return attrs
+	}
+
+	body = body[:min(len(body), l.bodyLimit)]
+	if size > int64(len(body)) {
+		attrs = append(attrs,
+			slog.String("body", strings.ToValidUTF8(string(body), string(utf8.RuneError))),
+			slog.Int64("body_size", size),
+			slog.Bool("body_truncated", true),
+		)
+		return attrs
+	}
+	return append(attrs, slog.String("body", string(body)))
+}
+
+// header returns the attributes of the headers in a stable order with the
+// values of redacted headers replaced.
+func (l *Logger) header(header map[string][]string) []any {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := strings.Join(header[k], ", ")
+		if l.redacts(k) {
+			v = redacted
+		}
+		attrs = append(attrs, slog.String(k, v))
+	}
+	return attrs
+}
+
+// redacts reports whether the values of the header name are redacted.
+func (l *Logger) redacts(name string) bool {
+	for _, pattern := range l.redact {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	l := accesslog.New(accesslog.WithBodyLimit(5), accesslog.WithRedactedHeaders("Authorization", "X-Secret-*"))
+	l.Log(context.Background(), accesslog.Exchange{
+		Protocol: "http",
+		Method:   "POST",
+		Path:     "/orders",
+		Status:   "201",
+		RequestHeader: map[string][]string{
+			"Authorization":  {"Bearer token"},
+			"X-Secret-Token": {"secret"},
+			"X-Request-Id":   {"abc"},
+		},
+		RequestBody:  []byte(`{"id":1}`),
+		ResponseBody: []byte(`{}`),
+	})
+
+	var got struct {
+		Request struct {
+			Header        map[string]string `json:"header"`
+			Body          string            `json:"body"`
+			BodySize      int               `json:"body_size"`
+			BodyTruncated bool              `json:"body_truncated"`
+		} `json:"request"`
+		Response struct {
+			Body string `json:"body"`
+		} `json:"response"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, map[string]string{"Authorization": "REDACTED", "X-Secret-Token": "REDACTED", "X-Request-Id": "abc"}, got.Request.Header)
+	assert.Equal(t, `{"id"`, got.Request.Body)
+	assert.Equal(t, 8, got.Request.BodySize)
+	assert.True(t, got.Request.BodyTruncated)
+	assert.Equal(t, `{}`, got.Response.Body)
+
+	var nilLogger *accesslog.Logger
+	buf.Reset()
+	nilLogger.Log(context.Background(), accesslog.Exchange{})
+	assert.Empty(t, buf.String())
+}
+
+func TestLogBodyLimit(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	type body struct {
+		Body          string `json:"body"`
+		BodySize      int    `json:"body_size"`
+		BodyTruncated bool   `json:"body_truncated"`
+	}
+	tests := []struct {
+		name     string
+		limit    int
+		request  body
+		response body
+	}{
+		{name: "Default", limit: accesslog.DefaultBodyLimit, request: body{Body: `{"id":1}`}, response: body{Body: `{"id"`, BodySize: 8, BodyTruncated: true}},
+		{name: "Zero", limit: 0},
+		{name: "Negative", limit: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The own logger logs although the default one is above info.
+			var out bytes.Buffer
+			l := accesslog.New(accesslog.WithBodyLimit(tt.limit), accesslog.WithLogger(slog.New(slog.NewJSONHandler(&out, nil))))
+			require.True(t, l.Enabled(context.Background()))
+			l.Log(context.Background(), accesslog.Exchange{
+				RequestBody:      []byte(`{"id":1}`),
+				ResponseBody:     []byte(`{"id"`),
+				ResponseBodySize: 8,
+			})
+
+			var got struct {
+				Request  body `json:"request"`
+				Response body `json:"response"`
+			}
+			require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+			assert.Equal(t, tt.request, got.Request)
+			assert.Equal(t, tt.response, got.Response)
+		})
+	}
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,92 @@
+// Package archive opens zip and tar archives of stub files and protos as an
+// fs.FS.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing/fstest"
+)
+
+// Is reports whether the file at name has the extension of a supported
+// archive format: .zip, .tar, .tar.gz or .tgz.
+func Is(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the archive at name as a read-only file system. Zip archives are
+// read on demand and have to be closed with the returned io.Closer, tar
+// archives are read into memory at once.
+func Open(name string) (fs.FS, io.Closer, error) {
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open zip archive: %w", err)
+		}
+		return r, r, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open tar archive: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if lower := strings.ToLower(name); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open tar archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	fsys, err := readTar(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read tar archive %v: %w", name, err)
+	}
+	return fsys, io.NopCloser(nil), nil
+}
+
+// readTar reads the regular files of the tar archive in r into memory.
+func readTar(r io.Reader) (fs.FS, error) {
+	fsys := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid file name %q", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %v: %w", hdr.Name, err)
+		}
+		fsys[name] = &fstest.MapFile{Data: data, Mode: hdr.FileInfo().Mode(), ModTime: hdr.ModTime}
+	}
+}
@@ -0,0 +1,91 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kogxi/stub-server/internal/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var files = map[string]string{
+	"stubs/a.json": `{"path": "/a"}`,
+	"b.yaml":       "path: /b\n",
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{name: "stubs.zip", write: writeZip},
+		{name: "stubs.tar", write: writeTar},
+		{name: "stubs.tar.gz", write: func(w io.Writer) error {
+			gz := gzip.NewWriter(w)
+			if err := writeTar(gz); err != nil {
+				return err
+			}
+			return gz.Close()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			name := filepath.Join(t.TempDir(), tt.name)
+			f, err := os.Create(name)
+			require.NoError(t, err)
+			require.NoError(t, tt.write(f))
+			require.NoError(t, f.Close())
+
+			require.True(t, archive.Is(name))
+			fsys, closer, err := archive.Open(name)
+			require.NoError(t, err)
+			defer closer.Close()
+
+			for name, content := range files {
+				data, err := fs.ReadFile(fsys, name)
+				require.NoError(t, err)
+				assert.Equal(t, content, string(data))
+			}
+		})
+	}
+}
+
+func writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,95 @@
+package grpcstub
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// registerDescriptorSet registers the files of the FileDescriptorSet stored
+// at path, as written by "buf build -o" or "protoc --descriptor_set_out". The
+// set may be encoded in the binary or the JSON format.
+func (s *GRPCService) registerDescriptorSet(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read descriptor set: %w", err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	// A binary set starts with the tag of its first file, never with '{'.
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = protojson.Unmarshal(data, &set)
+	} else {
+		err = proto.Unmarshal(data, &set)
+	}
+	if err != nil {
+		return fmt.Errorf("unmarshal descriptor set %v: %w", path, err)
+	}
+
+	if err := s.registerFileSet(&set); err != nil {
+		return fmt.Errorf("register descriptor set %v: %w", path, err)
+	}
+	return nil
+}
+
+// registerFileSet registers all files of set. Dependencies missing from the
+// set are only allowed for the well-known types, so sets built without their
+// imports still work for the common case.
+func (s *GRPCService) registerFileSet(set *descriptorpb.FileDescriptorSet) error {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(set.GetFile()))
+	for _, fdp := range set.GetFile() {
+		byName[fdp.GetName()] = fdp
+	}
+
+	var register func(name string) error
+	register = func(name string) error {
+		if _, err := s.files.FindFileByPath(name); err == nil {
+			return nil
+		}
+
+		fdp, ok := byName[name]
+		if !ok {
+			fd, ok := standardFile(name)
+			if !ok {
+				return fmt.Errorf("missing dependency %q", name)
+			}
+			return s.registerFile(fd)
+		}
+
+		for _, dep := range fdp.GetDependency() {
+			if err := register(dep); err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+		}
+
+		fd, err := protodesc.NewFile(fdp, s.files)
+		if err != nil {
+			return fmt.Errorf("convert to FileDescriptor: %w", err)
+		}
+		return s.registerFile(fd)
+	}
+
+	for _, fdp := range set.GetFile() {
+		if err := register(fdp.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// standardFile returns the descriptor of a well-known type file like
+// google/protobuf/timestamp.proto or of a google.api HTTP annotation file.
+func standardFile(name string) (protoreflect.FileDescriptor, bool) {
+	res, err := protocompile.WithStandardImports(googleAPIResolver).FindFileByPath(name)
+	if err != nil || res.Desc == nil {
+		return nil, false
+	}
+	return res.Desc, true
+}
@@ -0,0 +1,51 @@
+package grpcstub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	routeguide "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestNewServerFromDescriptorSet(t *testing.T) {
+	t.Parallel()
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(helloworldpb.File_examples_helloworld_helloworld_helloworld_proto),
+		protodesc.ToFileDescriptorProto(routeguide.File_examples_route_guide_routeguide_route_guide_proto),
+	}}
+
+	binary, err := proto.Marshal(set)
+	require.NoError(t, err)
+	json, err := protojson.Marshal(set)
+	require.NoError(t, err)
+
+	for name, data := range map[string][]byte{"set.binpb": binary, "set.json": json} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+
+			srv, err := NewServer("", "../../examples/protostubs", WithDescriptorSets(path))
+			require.NoError(t, err)
+			assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "helloworld.Greeter")
+			assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "routeguide.RouteGuide")
+		})
+	}
+
+	t.Run("No protos", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewServer("", "../../examples/protostubs")
+		require.ErrorIs(t, err, errNoProtos)
+	})
+}
@@ -0,0 +1,317 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const defaultFakeMaxLength = 3
+
+// maxFakeListIndex is the largest list index overrides can address, as lists
+// are grown with generated elements up to the index.
+const maxFakeListIndex = 999
+
+// Fake configures the synthesis of response messages from the descriptor of
+// the method's output type, for stubs where only the shape of the response
+// matters.
+type Fake struct {
+	// Seed makes the generated responses reproducible. Without a seed every
+	// response is different.
+	Seed *uint64 `json:"seed,omitempty"`
+	// MaxLength is the maximum number of elements of repeated and map fields.
+	// It defaults to 3.
+	MaxLength int `json:"max_length,omitempty"`
+	// Count is the number of messages sent by server streaming methods. It
+	// defaults to 1.
+	Count int `json:"count,omitempty"`
+	// Overrides sets fields to fixed values. The keys are dot separated paths
+	// of proto field names, list elements are addressed by their index up to
+	// 999, e.g. "features.0.name".
+	Overrides map[string]json.RawMessage `json:"overrides,omitempty"`
+}
+
+func (f *Fake) validate() error {
+	if f.MaxLength < 0 {
+		return fmt.Errorf(`"max_length" can't be negative`)
+	}
+	if f.Count < 0 {
+		return fmt.Errorf(`"count" can't be negative`)
+	}
+	return nil
+}
+
+// count returns the number of messages to send for server streaming methods.
+func (f *Fake) count() int {
+	if f.Count == 0 {
+		return 1
+	}
+	return f.Count
+}
+
+// newGenerator returns the generator for one response. Messages of a stream
+// share a generator, so that they differ from each other.
+func (f *Fake) newGenerator() *fakeValues {
+	seed := rand.Uint64()
+	if f.Seed != nil {
+		seed = *f.Seed
+	}
+
+	maxLength := f.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultFakeMaxLength
+	}
+
+	return &fakeValues{
+		rnd:       rand.New(rand.NewPCG(seed, seed)),
+		maxLength: maxLength,
+	}
+}
+
+// message synthesizes a message of type md and applies the overrides. The
+// types of Any fields in the overrides are resolved from types.
+func (f *Fake) message(md protoreflect.MessageDescriptor, gen *fakeValues, types *protoregistry.Types) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	populate(msg, gen)
+
+	// Overrides are applied in a fixed order, so that overlapping paths give
+	// the same result every time.
+	for _, path := range slices.Sorted(maps.Keys(f.Overrides)) {
+		if err := setPath(msg, strings.Split(path, "."), f.Overrides[path], gen, types); err != nil {
+			return nil, fmt.Errorf("override %q: %w", path, err)
+		}
+	}
+	return msg, nil
+}
+
+// setPath sets the field at path in msg to the JSON value raw. Missing
+// messages on the way are created, lists are grown with generated elements up
+// to the addressed index, and setting a field of a oneof clears the other
+// fields of the oneof.
+func setPath(msg protoreflect.Message, path []string, raw json.RawMessage, gen *fakeValues, types *protoregistry.Types) error {
+	md := msg.Descriptor()
+	fd := md.Fields().ByName(protoreflect.Name(path[0]))
+	if fd == nil {
+		fd = md.Fields().ByJSONName(path[0])
+	}
+	if fd == nil {
+		return fmt.Errorf("%v has no field %q", md.FullName(), path[0])
+	}
+	rest := path[1:]
+
+	switch {
+	case len(rest) == 0:
+		v, err := decodeField(md, fd, raw, types)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, v)
+		return nil
+	case fd.IsList():
+		i, err := strconv.Atoi(rest[0])
+		if err != nil || i < 0 {
+			return fmt.Errorf("no list element %q", rest[0])
+		}
+		if i > maxFakeListIndex {
+			return fmt.Errorf("list index %d is larger than %d", i, maxFakeListIndex)
+		}
+		l := msg.Mutable(fd).List()
+		for l.Len() <= i {
+			l.Append(fieldValue(l.NewElement, fd, gen, map[protoreflect.FullName]bool{}))
+		}
+		if len(rest) == 1 {
+			v, err := decodeField(md, fd, json.RawMessage("["+string(raw)+"]"), types)
+			if err != nil {
+				return err
+			}
+			l.Set(i, v.List().Get(0))
+			return nil
+		}
+		if fd.Message() == nil {
+			return fmt.Errorf("%q is not a message, map or list", rest[0])
+		}
+		return setPath(l.Get(i).Message(), rest[1:], raw, gen, types)
+	case fd.IsMap():
+		value := raw
+		if len(rest) > 1 {
+			if fd.MapValue().Message() == nil {
+				return fmt.Errorf("%q is not a message, map or list", rest[0])
+			}
+			value = json.RawMessage("{}")
+		}
+		entry, err := json.Marshal(map[string]json.RawMessage{rest[0]: value})
+		if err != nil {
+			return err
+		}
+		v, err := decodeField(md, fd, entry, types)
+		if err != nil {
+			return err
+		}
+		m := msg.Mutable(fd).Map()
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			if len(rest) == 1 {
+				m.Set(k, v)
+				return false
+			}
+			if !m.Has(k) {
+				m.Set(k, fieldValue(m.NewValue, fd.MapValue(), gen, map[protoreflect.FullName]bool{}))
+			}
+			err = setPath(m.Mutable(k).Message(), rest[1:], raw, gen, types)
+			return false
+		})
+		return err
+	case fd.Message() != nil:
+		return setPath(msg.Mutable(fd).Message(), rest, raw, gen, types)
+	default:
+		return fmt.Errorf("%q is not a message, map or list", path[0])
+	}
+}
+
+// decodeField decodes the JSON value raw of the field fd of a message of type
+// md.
+func decodeField(md protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor, raw json.RawMessage, types *protoregistry.Types) (protoreflect.Value, error) {
+	data, err := json.Marshal(map[string]json.RawMessage{string(fd.Name()): raw})
+	if err != nil {
+		return protoreflect.Value{}, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal(data, msg); err != nil {
+		return protoreflect.Value{}, err
+	}
+	return msg.Get(fd), nil
+}
+
+// fakeValues generates random values. Values of string fields are chosen by
+// the name of the field, so that e.g. "email" fields contain email addresses.
+type fakeValues struct {
+	rnd       *rand.Rand
+	maxLength int
+}
+
+var _ valueGenerator = &fakeValues{}
+
+var (
+	fakeFirstNames = []string{"Ada", "Alan", "Grace", "Linus", "Barbara", "Dennis", "Margaret", "Ken", "Radia", "Edsger"}
+	fakeLastNames  = []string{"Lovelace", "Turing", "Hopper", "Torvalds", "Liskov", "Ritchie", "Hamilton", "Thompson", "Perlman", "Dijkstra"}
+	fakeCities     = []string{"Berlin", "Lisbon", "Oslo", "Tokyo", "Toronto", "Nairobi", "Lima", "Sydney"}
+	fakeCountries  = []string{"Germany", "Portugal", "Norway", "Japan", "Canada", "Kenya", "Peru", "Australia"}
+	fakeStreets    = []string{"Main Street", "Station Road", "Park Avenue", "Mill Lane", "Church Street"}
+	fakeWords      = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliett", "kilo", "lima"}
+)
+
+// fakeEpoch is the start of the range of generated timestamps. A fixed start
+// keeps seeded responses reproducible.
+var fakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func (g *fakeValues) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(g.rnd.IntN(2) == 1)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(g.rnd.Int32N(1000))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(g.rnd.Int64N(1000))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(g.rnd.Uint32N(1000))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(g.rnd.Uint64N(1000))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(g.rnd.IntN(100000)) / 100)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(g.rnd.IntN(100000)) / 100)
+	case protoreflect.BytesKind:
+		b := make([]byte, 8)
+		for i := range b {
+			b[i] = byte(g.rnd.UintN(256))
+		}
+		return protoreflect.ValueOfBytes(b)
+	default:
+		return protoreflect.ValueOfString(g.text(string(fd.Name())))
+	}
+}
+
+// text returns a string that fits a field with the given name.
+func (g *fakeValues) text(name string) string {
+	name = strings.ToLower(name)
+	first := pick(g.rnd, fakeFirstNames)
+	last := pick(g.rnd, fakeLastNames)
+
+	switch {
+	case strings.Contains(name, "email"):
+		return strings.ToLower(first + "." + last + "@example.com")
+	case name == "id" || name == "uuid" || strings.HasSuffix(name, "_id") || strings.HasSuffix(name, "uuid"):
+		return g.uuid()
+	case strings.Contains(name, "url") || strings.Contains(name, "uri") || strings.Contains(name, "link"):
+		return "https://example.com/" + pick(g.rnd, fakeWords)
+	case strings.Contains(name, "phone"):
+		return fmt.Sprintf("+1-555-%04d", g.rnd.IntN(10000))
+	case strings.Contains(name, "first_name") || strings.Contains(name, "given_name"):
+		return first
+	case strings.Contains(name, "last_name") || strings.Contains(name, "family_name") || strings.Contains(name, "surname"):
+		return last
+	case strings.Contains(name, "username") || strings.Contains(name, "user_name") || strings.Contains(name, "login"):
+		return strings.ToLower(first) + strconv.Itoa(g.rnd.IntN(100))
+	case strings.Contains(name, "name"):
+		return first + " " + last
+	case strings.Contains(name, "city"):
+		return pick(g.rnd, fakeCities)
+	case strings.Contains(name, "country"):
+		return pick(g.rnd, fakeCountries)
+	case strings.Contains(name, "address") || strings.Contains(name, "street"):
+		return fmt.Sprintf("%d %s", 1+g.rnd.IntN(200), pick(g.rnd, fakeStreets))
+	case strings.HasSuffix(name, "_at") || strings.Contains(name, "time") || strings.Contains(name, "date"):
+		return g.timestamp().Format(time.RFC3339)
+	default:
+		words := make([]string, 1+g.rnd.IntN(3))
+		for i := range words {
+			words[i] = pick(g.rnd, fakeWords)
+		}
+		return strings.Join(words, " ")
+	}
+}
+
+func (g *fakeValues) uuid() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(g.rnd.UintN(256))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// enum returns a random value, preferring values other than the zero value,
+// which by convention means "unspecified".
+func (g *fakeValues) enum(ed protoreflect.EnumDescriptor) protoreflect.EnumNumber {
+	values := ed.Values()
+	if values.Len() > 1 {
+		return values.Get(1 + g.rnd.IntN(values.Len()-1)).Number()
+	}
+	return values.Get(0).Number()
+}
+
+func (g *fakeValues) length(protoreflect.FieldDescriptor) int {
+	return 1 + g.rnd.IntN(g.maxLength)
+}
+
+func (g *fakeValues) timestamp() time.Time {
+	return fakeEpoch.Add(time.Duration(g.rnd.Int64N(int64(365 * 24 * time.Hour))))
+}
+
+func (g *fakeValues) duration() time.Duration {
+	return time.Duration(1+g.rnd.IntN(3600)) * time.Second
+}
+
+func pick(rnd *rand.Rand, values []string) string {
+	return values[rnd.IntN(len(values))]
+}
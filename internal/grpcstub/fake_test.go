@@ -0,0 +1,91 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFake(t *testing.T) {
+	t.Parallel()
+
+	s, err := newService(grpc.NewServer(), "testdata/protos", NewStorage(), newOptions([]Option{WithImportPaths("testdata/imports")}))
+	require.NoError(t, err)
+	item := s.sdMap["example.v1.ExampleService"].Methods().ByName("GetItem").Output()
+
+	seed := uint64(42)
+	fake := &Fake{
+		Seed: &seed,
+		Overrides: map[string]json.RawMessage{
+			"name":       json.RawMessage(`"fixed"`),
+			"attributes": json.RawMessage(`{"size": "3"}`),
+		},
+	}
+
+	first, err := fake.message(item, fake.newGenerator(), s.types)
+	require.NoError(t, err)
+	second, err := fake.message(item, fake.newGenerator(), s.types)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(first, second), "seeded messages differ")
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(first)
+	require.NoError(t, err)
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal(data, &fields))
+
+	assert.Equal(t, "fixed", fields["name"])
+	assert.Equal(t, map[string]any{"size": "3"}, fields["attributes"])
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, fields["id"])
+	assert.Regexp(t, `^[a-z]+\.[a-z]+@example\.com$`, fields["user_email"])
+	assert.NotEqual(t, "STATUS_UNSPECIFIED", fields["status"])
+
+	t.Run("Oneof and list overrides", func(t *testing.T) {
+		for seed := range uint64(50) {
+			fake := &Fake{
+				Seed: &seed,
+				Overrides: map[string]json.RawMessage{
+					"team":            json.RawMessage(`"core"`),
+					"tags.2":          json.RawMessage(`"third"`),
+					"children.4.name": json.RawMessage(`"fifth"`),
+					"attributes.size": json.RawMessage(`"3"`),
+				},
+			}
+			msg, err := fake.message(item, fake.newGenerator(), s.types)
+			require.NoError(t, err, "seed %d", seed)
+
+			data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+			require.NoError(t, err)
+			var fields map[string]any
+			require.NoError(t, json.Unmarshal(data, &fields))
+
+			assert.Equal(t, "core", fields["team"])
+			assert.NotContains(t, fields, "user_email")
+			tags := fields["tags"].([]any)
+			require.GreaterOrEqual(t, len(tags), 3)
+			assert.Equal(t, "third", tags[2])
+			children := fields["children"].([]any)
+			require.GreaterOrEqual(t, len(children), 5)
+			assert.Equal(t, "fifth", children[4].(map[string]any)["name"])
+			assert.Equal(t, "3", fields["attributes"].(map[string]any)["size"])
+		}
+	})
+
+	t.Run("Invalid override", func(t *testing.T) {
+		for _, overrides := range []map[string]json.RawMessage{
+			{"count": json.RawMessage(`"many"`)},
+			{"nope": json.RawMessage(`1`)},
+			{"tags.x": json.RawMessage(`"a"`)},
+			{"tags.1000000000": json.RawMessage(`"a"`)},
+			{"name.first": json.RawMessage(`"a"`)},
+		} {
+			fake := &Fake{Overrides: overrides}
+			_, err := fake.message(item, fake.newGenerator(), s.types)
+			require.Error(t, err, "overrides %v", overrides)
+		}
+	})
+}
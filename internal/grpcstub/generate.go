@@ -0,0 +1,149 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Generate loads the proto definitions from protoDir and writes a skeleton
+// stub for every method of every loaded service to outDir. The output data of
+// the stubs has every field set to an example value. Existing files are only
+// replaced if overwrite is set. It returns the paths of the written files.
+func Generate(protoDir string, outDir string, overwrite bool, opts ...Option) ([]string, error) {
+	s, err := newService(grpc.NewServer(), protoDir, NewStorage(), newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+
+	services := make([]string, 0, len(s.sdMap))
+	for name := range s.sdMap {
+		services = append(services, name)
+	}
+	slices.Sort(services)
+
+	written := make([]string, 0)
+	for _, name := range services {
+		methods := s.sdMap[name].Methods()
+		for i := 0; i < methods.Len(); i++ {
+			m := methods.Get(i)
+			path := filepath.Join(outDir, fmt.Sprintf("%s.%s.json", name, m.Name()))
+
+			ok, err := writeStub(path, skeletonStub(m), overwrite)
+			if err != nil {
+				return written, fmt.Errorf("write stub for %v: %w", m.FullName(), err)
+			}
+			if ok {
+				written = append(written, path)
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// skeletonStub returns a stub for m whose output has the right shape for the
+// streaming type of m and contains an example output message.
+func skeletonStub(m protoreflect.MethodDescriptor) ProtoStub {
+	output := dynamicpb.NewMessage(m.Output())
+	populate(output, exampleValues{})
+
+	// The example values are always valid, so marshalling can't fail.
+	data, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(output)
+
+	stub := ProtoStub{
+		Service: string(m.Parent().FullName()),
+		Method:  string(m.Name()),
+	}
+	if m.IsStreamingServer() {
+		stub.Output.Stream = &Stream{Data: []json.RawMessage{data}}
+	} else {
+		stub.Output.Data = data
+	}
+	return stub
+}
+
+func writeStub(path string, stub ProtoStub, overwrite bool) (bool, error) {
+	data, err := json.MarshalIndent(stub, "", "    ")
+	if err != nil {
+		return false, fmt.Errorf("marshal stub: %w", err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open file: %w", err)
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	return true, errors.Join(err, f.Close())
+}
+
+// exampleValues generates deterministic, self-describing example values.
+type exampleValues struct{}
+
+var _ valueGenerator = exampleValues{}
+
+func (exampleValues) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(1)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(1)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(1)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(1)
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(1.5)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(1.5)
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(fd.Name()))
+	default:
+		return protoreflect.ValueOfString(string(fd.Name()))
+	}
+}
+
+// enum returns the first value other than the zero value, which by convention
+// means "unspecified".
+func (exampleValues) enum(ed protoreflect.EnumDescriptor) protoreflect.EnumNumber {
+	values := ed.Values()
+	if values.Len() > 1 {
+		return values.Get(1).Number()
+	}
+	return values.Get(0).Number()
+}
+
+func (exampleValues) length(protoreflect.FieldDescriptor) int {
+	return 1
+}
+
+func (exampleValues) timestamp() time.Time {
+	return time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (exampleValues) duration() time.Duration {
+	return time.Second
+}
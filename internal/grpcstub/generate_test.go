@@ -0,0 +1,55 @@
+package grpcstub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	out := t.TempDir()
+
+	written, err := Generate("testdata/protos", out, false, WithImportPaths("testdata/imports"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(out, "example.v1.ExampleService.GetItem.json"),
+		filepath.Join(out, "example.v1.ExampleService.ListItems.json"),
+	}, written)
+
+	// The generated stubs are valid stubs for the methods they were generated from.
+	require.NoError(t, Validate("testdata/protos", out, WithImportPaths("testdata/imports")))
+
+	data, err := os.ReadFile(written[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"service": "example.v1.ExampleService",
+		"method": "GetItem",
+		"output": {
+			"data": {
+				"id": "id",
+				"name": "name",
+				"count": 1,
+				"price": 1.5,
+				"available": true,
+				"payload": "cGF5bG9hZA==",
+				"status": "STATUS_ACTIVE",
+				"tags": ["tags"],
+				"attributes": {"key": "1"},
+				"user_email": "user_email",
+				"created_at": "2024-01-01T00:00:00Z",
+				"price_money": {"currency_code": "currency_code", "units": "1"}
+			}
+		}
+	}`, string(data))
+
+	t.Run("Existing files are kept", func(t *testing.T) {
+		written, err := Generate("testdata/protos", out, false, WithImportPaths("testdata/imports"))
+		require.NoError(t, err)
+		assert.Empty(t, written)
+	})
+}
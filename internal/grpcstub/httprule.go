@@ -0,0 +1,110 @@
+package grpcstub
+
+import (
+	"slices"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// googleAPIFiles are the files declaring the google.api.http annotation. They
+// are used when they are not found in the proto or import directories, so
+// annotated protos compile without a copy of the googleapis repository.
+var googleAPIFiles = map[string]protoreflect.FileDescriptor{
+	"google/api/annotations.proto": annotations.File_google_api_annotations_proto,
+	"google/api/http.proto":        annotations.File_google_api_http_proto,
+}
+
+// googleAPIResolver resolves googleAPIFiles.
+var googleAPIResolver = protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+	fd, ok := googleAPIFiles[path]
+	if !ok {
+		return protocompile.SearchResult{}, protoregistry.NotFound
+	}
+	return protocompile.SearchResult{Desc: fd}, nil
+})
+
+// HTTPRule is a REST binding of a gRPC method, declared with the
+// google.api.http annotation.
+type HTTPRule struct {
+	Method protoreflect.MethodDescriptor
+	// HTTPMethod is the HTTP method of the binding, e.g. "GET".
+	HTTPMethod string
+	// Path is the path template, e.g. "/v1/{name=shelves/*}".
+	Path string
+	// Body is the request field the request body is mapped to, "*" for the
+	// whole request message or empty if the request has no body.
+	Body string
+	// ResponseBody is the response field sent as the response body or empty
+	// for the whole response message.
+	ResponseBody string
+}
+
+// HTTPRules returns the REST bindings of all methods, including their
+// additional bindings, ordered by service and method.
+func (s *GRPCService) HTTPRules() []HTTPRule {
+	names := make([]string, 0, len(s.sdMap))
+	for name := range s.sdMap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	rules := make([]HTTPRule, 0)
+	for _, name := range names {
+		methods := s.sdMap[name].Methods()
+		for i := 0; i < methods.Len(); i++ {
+			rules = append(rules, httpRules(methods.Get(i))...)
+		}
+	}
+	return rules
+}
+
+func httpRules(md protoreflect.MethodDescriptor) []HTTPRule {
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil {
+		return nil
+	}
+
+	// Options compiled from source or loaded from descriptor sets hold the
+	// annotation as a dynamic extension or as unknown fields. Round trip them
+	// to resolve it against the generated extension type.
+	data, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	opts = &descriptorpb.MethodOptions{}
+	if err := (proto.UnmarshalOptions{Resolver: protoregistry.GlobalTypes}).Unmarshal(data, opts); err != nil {
+		return nil
+	}
+	rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil
+	}
+
+	rules := make([]HTTPRule, 0, 1+len(rule.GetAdditionalBindings()))
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		binding := HTTPRule{Method: md, Body: r.GetBody(), ResponseBody: r.GetResponseBody()}
+		switch pattern := r.GetPattern().(type) {
+		case *annotations.HttpRule_Get:
+			binding.HTTPMethod, binding.Path = "GET", pattern.Get
+		case *annotations.HttpRule_Put:
+			binding.HTTPMethod, binding.Path = "PUT", pattern.Put
+		case *annotations.HttpRule_Post:
+			binding.HTTPMethod, binding.Path = "POST", pattern.Post
+		case *annotations.HttpRule_Delete:
+			binding.HTTPMethod, binding.Path = "DELETE", pattern.Delete
+		case *annotations.HttpRule_Patch:
+			binding.HTTPMethod, binding.Path = "PATCH", pattern.Patch
+		case *annotations.HttpRule_Custom:
+			binding.HTTPMethod, binding.Path = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+		default:
+			continue
+		}
+		rules = append(rules, binding)
+	}
+	return rules
+}
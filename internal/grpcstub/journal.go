@@ -0,0 +1,222 @@
+package grpcstub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/journal"
+	"github.com/kogxi/stub-server/internal/metrics"
+	"github.com/kogxi/stub-server/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// call collects the journal entry, the metrics, the span and the access log
+// of a gRPC call.
+type call struct {
+	ctx     context.Context
+	entry   journal.Entry
+	journal *journal.Journal
+	metrics *metrics.Metrics
+	span    trace.Span
+
+	// messagesSize is the size of the request messages in entry.
+	messagesSize int
+
+	// accessLog is only set if the call is logged, requests, header and
+	// replies hold the request and the response for it.
+	accessLog *accesslog.Logger
+	marshal   protojson.MarshalOptions
+	requests  lines
+	header    metadata.MD
+	replies   lines
+
+	start time.Time
+	// delay is the time spent waiting for configured delays.
+	delay time.Duration
+}
+
+func (s *GRPCService) newCall(ctx context.Context, service string, method string) *call {
+	md, _ := metadata.FromIncomingContext(ctx)
+	_, span := s.tracer.Start(tracing.ExtractGRPC(ctx, md), service+"/"+method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", method),
+		),
+	)
+	echoed := s.echo.Metadata(md)
+	if len(echoed) > 0 {
+		if err := grpc.SetHeader(ctx, echoed); err != nil {
+			slog.WarnContext(ctx, "Failed to echo metadata", slog.String("error", err.Error()))
+		}
+	}
+
+	c := &call{
+		ctx: ctx,
+		entry: journal.Entry{
+			Protocol: journal.ProtocolGRPC,
+			Service:  service,
+			Method:   method,
+			Header:   md,
+		},
+		journal: s.journal,
+		metrics: s.metrics,
+		span:    span,
+		start:   time.Now(),
+	}
+	if s.accessLog.Enabled(ctx) {
+		c.accessLog = s.accessLog
+		c.marshal = s.marshalOptions()
+		c.header = echoed
+		c.requests.limit = s.accessLog.BodyLimit()
+		c.replies.limit = s.accessLog.BodyLimit()
+	}
+	return c
+}
+
+// message records the request message msg for the journal, up to
+// journal.MaxBodySize bytes of messages, and for the access log.
+func (c *call) message(msg json.RawMessage) {
+	if c.journal != nil && !c.entry.MessagesTruncated {
+		if c.messagesSize+len(msg) > journal.MaxBodySize {
+			c.entry.MessagesTruncated = true
+		} else {
+			c.entry.Messages = append(c.entry.Messages, msg)
+			c.messagesSize += len(msg)
+		}
+	}
+	if c.accessLog != nil {
+		c.requests.add(msg)
+	}
+}
+
+func (c *call) matched() {
+	c.entry.Matched = true
+}
+
+// reply records the response message msg for the access log.
+func (c *call) reply(msg any) {
+	m, ok := msg.(proto.Message)
+	if c.accessLog == nil || c.replies.limit == 0 || !ok {
+		return
+	}
+	data, err := c.marshal.Marshal(m)
+	if err != nil {
+		slog.WarnContext(c.ctx, "Failed to marshal response for the access log", slog.String("error", err.Error()))
+		return
+	}
+	c.replies.add(data)
+}
+
+// stream returns stream recording the sent messages for the access log.
+func (c *call) stream(stream grpc.ServerStream) grpc.ServerStream {
+	if c.accessLog == nil {
+		return stream
+	}
+	return &recordingStream{ServerStream: stream, call: c}
+}
+
+// recordingStream records the messages sent on a stream with reply.
+type recordingStream struct {
+	grpc.ServerStream
+	call *call
+}
+
+func (s *recordingStream) SendMsg(m any) error {
+	if err := s.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	s.call.reply(m)
+	return nil
+}
+
+// sleep waits for delay like sleep and adds it to the delay of the call.
+func (c *call) sleep(ctx context.Context, delay time.Duration) error {
+	if err := sleep(ctx, delay); err != nil {
+		return err
+	}
+	c.delay += max(delay, 0)
+	return nil
+}
+
+// finish records and logs the call with the status code of err and ends its
+// span.
+func (c *call) finish(err error) {
+	code := status.Code(err)
+	c.entry.Status = int(code)
+	c.journal.Record(c.entry)
+
+	c.span.SetAttributes(
+		attribute.Int("rpc.grpc.status_code", int(code)),
+		tracing.AttrStubMatched.Bool(c.entry.Matched),
+	)
+	if c.entry.Matched {
+		c.span.SetAttributes(tracing.AttrStub.String(c.entry.Service + "/" + c.entry.Method))
+	}
+	if code != codes.OK {
+		c.span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	c.span.End()
+
+	c.metrics.Observe(metrics.Call{
+		Protocol: c.entry.Protocol,
+		Service:  c.entry.Service,
+		Method:   c.entry.Method,
+		Matched:  c.entry.Matched,
+		Status:   code.String(),
+		Duration: time.Since(c.start),
+		Delay:    c.delay,
+	})
+
+	if c.accessLog == nil {
+		return
+	}
+	c.accessLog.Log(c.ctx, accesslog.Exchange{
+		Protocol:         c.entry.Protocol,
+		Service:          c.entry.Service,
+		Method:           c.entry.Method,
+		Matched:          c.entry.Matched,
+		Status:           code.String(),
+		Duration:         time.Since(c.start),
+		RequestHeader:    c.entry.Header,
+		RequestBody:      c.requests.data,
+		RequestBodySize:  c.requests.size,
+		ResponseHeader:   c.header,
+		ResponseBody:     c.replies.data,
+		ResponseBodySize: c.replies.size,
+	})
+}
+
+// lines joins JSON messages into one per line, keeping the first limit bytes
+// and the size of the whole text.
+type lines struct {
+	limit int
+	data  []byte
+	size  int64
+}
+
+func (l *lines) add(msg []byte) {
+	if l.size > 0 {
+		l.write([]byte{'\n'})
+	}
+	l.write(msg)
+}
+
+func (l *lines) write(b []byte) {
+	l.size += int64(len(b))
+	if rest := l.limit - len(l.data); rest > 0 {
+		l.data = append(l.data, b[:min(len(b), rest)]...)
+	}
+}
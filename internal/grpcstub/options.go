@@ -0,0 +1,159 @@
+package grpcstub
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/journal"
+	"github.com/kogxi/stub-server/internal/metrics"
+	"github.com/kogxi/stub-server/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+)
+
+// Option configures how a gRPC stub server loads its proto definitions.
+type Option func(*options)
+
+type options struct {
+	protoFS fs.FS
+	stubFS  fs.FS
+
+	importPaths    []string
+	descriptorSets []string
+
+	reflectionTarget string
+	reflectionCache  string
+
+	journal   *journal.Journal
+	metrics   *metrics.Metrics
+	accessLog *accesslog.Logger
+
+	tracerProvider trace.TracerProvider
+	echo           tracing.Echo
+
+	delay         time.Duration
+	unmatchedCode codes.Code
+}
+
+func newOptions(opts []Option) options {
+	o := options{unmatchedCode: codes.NotFound}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// HasProtos reports whether proto definitions are configured to be loaded,
+// from protoDir or from a proto FS, descriptor sets or a reflection target in
+// opts.
+func HasProtos(protoDir string, opts ...Option) bool {
+	o := newOptions(opts)
+	return o.hasProtos(protoDir)
+}
+
+func (o options) hasProtos(protoDir string) bool {
+	return protoDir != "" || o.protoFS != nil || len(o.descriptorSets) > 0 || o.reflectionTarget != ""
+}
+
+// WithProtoFS compiles the .proto files in fsys, e.g. an embed.FS or a zip
+// archive, instead of those in the proto directory.
+func WithProtoFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.protoFS = fsys
+	}
+}
+
+// WithStubFS loads the stub files in fsys instead of those in the stub
+// directory.
+func WithStubFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.stubFS = fsys
+	}
+}
+
+// WithImportPaths adds directories in which imports of the proto files are
+// looked up, in addition to the proto directory itself. The well-known types
+// of google/protobuf are always available.
+func WithImportPaths(paths ...string) Option {
+	return func(o *options) {
+		o.importPaths = append(o.importPaths, paths...)
+	}
+}
+
+// WithDescriptorSets loads proto definitions from FileDescriptorSet files in
+// the binary or JSON format, in addition to or instead of a proto directory.
+func WithDescriptorSets(paths ...string) Option {
+	return func(o *options) {
+		o.descriptorSets = append(o.descriptorSets, paths...)
+	}
+}
+
+// WithReflection loads the proto definitions served by the gRPC reflection
+// service of the server at target, e.g. "localhost:50051", over a plaintext
+// connection. If cacheFile is not empty, the definitions are written to it as
+// a binary FileDescriptorSet, which is used instead if the server can't be
+// reached and can be loaded with WithDescriptorSets for offline use.
+func WithReflection(target string, cacheFile string) Option {
+	return func(o *options) {
+		o.reflectionTarget = target
+		o.reflectionCache = cacheFile
+	}
+}
+
+// WithJournal records every call served by the stub server in j.
+func WithJournal(j *journal.Journal) Option {
+	return func(o *options) {
+		o.journal = j
+	}
+}
+
+// WithMetrics records the metrics of every call served by the stub server in
+// m.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithTracerProvider creates a span with the tracers of tp for every call
+// served by the stub server, continuing the trace of the caller propagated in
+// the traceparent metadata.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithEchoHeaders sends the request metadata matching patterns, e.g.
+// "traceparent" or "x-b3-*", back as response header metadata of every call.
+// See tracing.Echo for the patterns.
+func WithEchoHeaders(patterns ...string) Option {
+	return func(o *options) {
+		o.echo = append(o.echo, patterns...)
+	}
+}
+
+// WithAccessLog logs every call served by the stub server with its response
+// messages with l.
+func WithAccessLog(l *accesslog.Logger) Option {
+	return func(o *options) {
+		o.accessLog = l
+	}
+}
+
+// WithDelay delays every response of a matched stub by d, in addition to the
+// delay between stream messages of the stub itself.
+func WithDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.delay = d
+	}
+}
+
+// WithUnmatchedCode sets the status code returned for calls no stub matches.
+// It defaults to NotFound.
+func WithUnmatchedCode(code codes.Code) Option {
+	return func(o *options) {
+		o.unmatchedCode = code
+	}
+}
@@ -0,0 +1,117 @@
+package grpcstub
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// valueGenerator provides the values used by populate.
+type valueGenerator interface {
+	// scalar returns a value for a field of a scalar kind, i.e. neither a
+	// message nor an enum. For map fields it is also used for the keys.
+	scalar(fd protoreflect.FieldDescriptor) protoreflect.Value
+	// enum returns one of the values of ed.
+	enum(ed protoreflect.EnumDescriptor) protoreflect.EnumNumber
+	// length returns the number of elements of a repeated or map field.
+	length(fd protoreflect.FieldDescriptor) int
+	timestamp() time.Time
+	duration() time.Duration
+}
+
+// populate sets every field of msg using the values of gen. Nested messages
+// are populated recursively, well-known types get values that are valid in
+// their JSON representation, and of each oneof only the first field is set.
+// Fields of recursive message types are left empty once the type is already
+// being populated further up.
+func populate(msg protoreflect.Message, gen valueGenerator) {
+	populateMessage(msg, gen, map[protoreflect.FullName]bool{})
+}
+
+func populateMessage(msg protoreflect.Message, gen valueGenerator, parents map[protoreflect.FullName]bool) {
+	md := msg.Descriptor()
+	if populateWellKnown(msg, gen) {
+		return
+	}
+
+	parents[md.FullName()] = true
+	defer delete(parents, md.FullName())
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() && oneof.Fields().Get(0) != fd {
+			continue
+		}
+		if md := fieldMessage(fd); md != nil && parents[md.FullName()] {
+			continue
+		}
+
+		switch {
+		case fd.IsMap():
+			m := msg.Mutable(fd).Map()
+			for n := gen.length(fd); n > 0; n-- {
+				key := gen.scalar(fd.MapKey()).MapKey()
+				m.Set(key, fieldValue(m.NewValue, fd.MapValue(), gen, parents))
+			}
+		case fd.IsList():
+			l := msg.Mutable(fd).List()
+			for n := gen.length(fd); n > 0; n-- {
+				l.Append(fieldValue(l.NewElement, fd, gen, parents))
+			}
+		default:
+			msg.Set(fd, fieldValue(func() protoreflect.Value { return msg.NewField(fd) }, fd, gen, parents))
+		}
+	}
+}
+
+// fieldValue returns a single value for fd. newValue creates an empty value
+// for message fields.
+func fieldValue(newValue func() protoreflect.Value, fd protoreflect.FieldDescriptor, gen valueGenerator, parents map[protoreflect.FullName]bool) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		v := newValue()
+		populateMessage(v.Message(), gen, parents)
+		return v
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(gen.enum(fd.Enum()))
+	default:
+		return gen.scalar(fd)
+	}
+}
+
+// fieldMessage returns the message type of the values of fd, or nil if they
+// aren't messages.
+func fieldMessage(fd protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	if fd.IsMap() {
+		return fd.MapValue().Message()
+	}
+	return fd.Message()
+}
+
+// populateWellKnown populates the well-known types whose JSON representation
+// restricts the values of their fields. It reports whether msg was handled.
+func populateWellKnown(msg protoreflect.Message, gen valueGenerator) bool {
+	md := msg.Descriptor()
+	fields := md.Fields()
+
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		t := gen.timestamp()
+		msg.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		msg.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+	case "google.protobuf.Duration":
+		d := gen.duration()
+		msg.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		msg.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+	case "google.protobuf.Value":
+		msg.Set(fields.ByName("string_value"), gen.scalar(fields.ByName("string_value")))
+	case "google.protobuf.Any", "google.protobuf.FieldMask", "google.protobuf.Empty":
+		// An Any needs a resolvable type and a FieldMask paths of the
+		// message it applies to, so both are left empty.
+	default:
+		return false
+	}
+	return true
+}
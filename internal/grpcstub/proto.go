@@ -1,53 +1,82 @@
 package grpcstub
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
-	"os"
-	"path"
 	"path/filepath"
-	"strings"
 
-	"github.com/bufbuild/protocompile/parser"
+	"github.com/bufbuild/protocompile"
 	"github.com/bufbuild/protocompile/reporter"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
-// registerTypes loads all .proto files from the specified directory and registers them
-// with the gRPC server.
-func (s *GRPCService) registerTypes(protoDir string) error {
-	err := filepath.WalkDir(protoDir, func(path string, d os.DirEntry, err error) error {
+// registerTypes compiles all .proto files in protoFS and registers them with
+// the gRPC server. Imports are resolved relative to the root of protoFS, then
+// to each of the importPaths, and finally against the well-known types and the
+// google.api HTTP annotations.
+func (s *GRPCService) registerTypes(protoFS fs.FS, importPaths []string) error {
+	names := make([]string, 0)
+	err := fs.WalkDir(protoFS, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !d.IsDir() {
-			if filepath.Ext(path) != ".proto" {
-				return nil
-			}
-			n, err := filepath.Rel(protoDir, path)
-			if err != nil {
-				return err
-			}
-
-			return s.registerProto(protoDir, n)
+		if !d.IsDir() && filepath.Ext(path) == ".proto" {
+			names = append(names, path)
 		}
 		return nil
 	})
-
 	if err != nil {
 		return fmt.Errorf("register services: %w", err)
 	}
 
+	resolvers := protocompile.CompositeResolver{
+		&protocompile.SourceResolver{
+			Accessor: func(path string) (io.ReadCloser, error) {
+				return protoFS.Open(path)
+			},
+		},
+	}
+	if len(importPaths) > 0 {
+		resolvers = append(resolvers, &protocompile.SourceResolver{ImportPaths: importPaths})
+	}
+	resolvers = append(resolvers, googleAPIResolver)
+
+	// Report all problems at once instead of stopping at the first one. Each
+	// error is prefixed with the file, line and column it refers to.
+	var errs []error
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(resolvers),
+		Reporter: reporter.NewReporter(func(err reporter.ErrorWithPos) error {
+			errs = append(errs, err)
+			return nil
+		}, nil),
+	}
+
+	files, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		if len(errs) > 0 {
+			return fmt.Errorf("compile protos: %w", errors.Join(errs...))
+		}
+		return fmt.Errorf("compile protos: %w", err)
+	}
+
+	for _, fd := range files {
+		if err := s.registerFile(fd); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (s *GRPCService) registerServices() {
-	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
 		for svcNum := 0; svcNum < fd.Services().Len(); svcNum++ {
 			svc := fd.Services().Get(svcNum)
 			serviceName := string(svc.FullName())
@@ -72,67 +101,48 @@ func (s *GRPCService) registerServices() {
 	})
 }
 
-func (s *GRPCService) registerProto(protoDir string, protoFileName string) (err error) {
-	protoFileName = strings.ReplaceAll(protoFileName, "\\", "/")
-
+// registerFile registers fd and the files it imports with the registries of s.
+func (s *GRPCService) registerFile(fd protoreflect.FileDescriptor) error {
 	// Skip the file if it is already registered
-	if _, err := protoregistry.GlobalFiles.FindFileByPath(protoFileName); err == nil {
+	if _, err := s.files.FindFileByPath(fd.Path()); err == nil {
 		return nil
 	}
 
-	f, err := os.Open(path.Join(protoDir, protoFileName))
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
-	}
-	defer func() {
-		closeErr := f.Close()
-		if closeErr != nil {
-			err = errors.Join(err, fmt.Errorf("close file: %w", closeErr))
-		}
-	}()
-
-	handler := reporter.NewHandler(nil)
-	node, err := parser.Parse(protoFileName, f, handler)
-	if err != nil {
-		return fmt.Errorf("parse proto: %w", err)
-	}
-
-	res, err := parser.ResultFromAST(node, true, handler)
-	if err != nil {
-		return fmt.Errorf("convert from AST: %w", err)
-	}
-
-	// recursively register dependencies
-	for _, d := range res.FileDescriptorProto().Dependency {
-		err = s.registerProto(protoDir, d)
-		if err != nil {
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err := s.registerFile(imports.Get(i).FileDescriptor); err != nil {
 			return err
 		}
 	}
 
-	fd, err := protodesc.NewFile(res.FileDescriptorProto(), protoregistry.GlobalFiles)
-	if err != nil {
-		return fmt.Errorf("convert to FileDescriptor: %w", err)
+	if err := s.files.RegisterFile(fd); err != nil {
+		return fmt.Errorf("register file %q: %w", fd.Path(), err)
 	}
 
-	if _, err = protoregistry.GlobalTypes.FindMessageByName(fd.FullName()); errors.Is(err, protoregistry.NotFound) {
-		if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
-			return fmt.Errorf("register file: %w", err)
-		}
-	}
-
-	for i := 0; i < fd.Messages().Len(); i++ {
-		msg := fd.Messages().Get(i)
-		if err := protoregistry.GlobalTypes.RegisterMessage(dynamicpb.NewMessageType(msg)); err != nil {
-			return fmt.Errorf("register message %q: %w", msg.FullName(), err)
-		}
+	if err := s.registerMessages(fd.Messages()); err != nil {
+		return err
 	}
 	for i := 0; i < fd.Extensions().Len(); i++ {
 		ext := fd.Extensions().Get(i)
-		if err := protoregistry.GlobalTypes.RegisterExtension(dynamicpb.NewExtensionType(ext)); err != nil {
+		if err := s.types.RegisterExtension(dynamicpb.NewExtensionType(ext)); err != nil {
 			return fmt.Errorf("register extension %q: %w", ext.FullName(), err)
 		}
 	}
 
 	return nil
 }
+
+// registerMessages registers the messages and their nested messages with the
+// type registry of s.
+func (s *GRPCService) registerMessages(msgs protoreflect.MessageDescriptors) error {
+	for i := 0; i < msgs.Len(); i++ {
+		msg := msgs.Get(i)
+		if err := s.types.RegisterMessage(dynamicpb.NewMessageType(msg)); err != nil {
+			return fmt.Errorf("register message %q: %w", msg.FullName(), err)
+		}
+		if err := s.registerMessages(msg.Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
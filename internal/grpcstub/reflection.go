@@ -0,0 +1,160 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionTimeout limits how long fetching the descriptors from a
+// reflection service may take.
+const reflectionTimeout = 30 * time.Second
+
+// registerReflection registers the files served by the reflection service at
+// target. If cacheFile is set, the fetched files are written to it as a
+// binary FileDescriptorSet, and read from it if the service can't be reached.
+func (s *GRPCService) registerReflection(target string, cacheFile string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reflectionTimeout)
+	defer cancel()
+
+	set, err := fetchDescriptors(ctx, target)
+	if err != nil {
+		if cacheFile == "" {
+			return fmt.Errorf("fetch descriptors from %v: %w", target, err)
+		}
+		if _, statErr := os.Stat(cacheFile); statErr != nil {
+			return fmt.Errorf("fetch descriptors from %v: %w", target, err)
+		}
+
+		slog.Warn("Failed to fetch descriptors, using cache",
+			slog.String("target", target),
+			slog.String("cache", cacheFile),
+			slog.String("error", err.Error()),
+		)
+		return s.registerDescriptorSet(cacheFile)
+	}
+
+	if cacheFile != "" {
+		data, err := proto.Marshal(set)
+		if err != nil {
+			return fmt.Errorf("marshal descriptor set: %w", err)
+		}
+		if err := os.WriteFile(cacheFile, data, 0o644); err != nil {
+			return fmt.Errorf("write descriptor cache: %w", err)
+		}
+	}
+
+	return s.registerFileSet(set)
+}
+
+// fetchDescriptors returns the files defining the services of the server at
+// target and all their dependencies, as served by its gRPC reflection service.
+func fetchDescriptors(ctx context.Context, target string) (_ *descriptorpb.FileDescriptorSet, err error) {
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close connection: %w", closeErr))
+		}
+	}()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open reflection stream: %w", err)
+	}
+	defer func() {
+		_ = stream.CloseSend()
+	}()
+
+	resp, err := reflectionCall(stream, &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	set := &descriptorpb.FileDescriptorSet{}
+	add := func(resp *reflectionpb.ServerReflectionResponse) error {
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fdp := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fdp); err != nil {
+				return fmt.Errorf("unmarshal file descriptor: %w", err)
+			}
+			if _, ok := files[fdp.GetName()]; !ok {
+				files[fdp.GetName()] = fdp
+				set.File = append(set.File, fdp)
+			}
+		}
+		return nil
+	}
+
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		if strings.HasPrefix(svc.GetName(), "grpc.reflection.") {
+			continue
+		}
+
+		resp, err := reflectionCall(stream, &reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: svc.GetName()},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get file of service %v: %w", svc.GetName(), err)
+		}
+		if err := add(resp); err != nil {
+			return nil, err
+		}
+	}
+
+	// Servers may only send the requested file, so fetch missing dependencies
+	// one by one. Appending to set.File extends the loop.
+	for i := 0; i < len(set.File); i++ {
+		for _, dep := range set.File[i].GetDependency() {
+			if _, ok := files[dep]; ok {
+				continue
+			}
+			if _, ok := standardFile(dep); ok {
+				continue
+			}
+
+			resp, err := reflectionCall(stream, &reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("get file %v: %w", dep, err)
+			}
+			if err := add(resp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// reflectionCall sends a single request on the reflection stream and waits for its response.
+func reflectionCall(stream reflectionpb.ServerReflection_ServerReflectionInfoClient, req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error %d: %v", e.GetErrorCode(), e.GetErrorMessage())
+	}
+	return resp, nil
+}
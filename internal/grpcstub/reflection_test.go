@@ -0,0 +1,50 @@
+package grpcstub
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	routeguide "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/grpc/reflection"
+)
+
+func TestNewServerFromReflection(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	upstream := grpc.NewServer()
+	helloworldpb.RegisterGreeterServer(upstream, helloworldpb.UnimplementedGreeterServer{})
+	routeguide.RegisterRouteGuideServer(upstream, routeguide.UnimplementedRouteGuideServer{})
+	reflection.Register(upstream)
+	go func() {
+		_ = upstream.Serve(lis)
+	}()
+	target := lis.Addr().String()
+	cache := filepath.Join(t.TempDir(), "cache.binpb")
+
+	srv, err := NewServer("", "../../examples/protostubs", WithReflection(target, cache))
+	require.NoError(t, err)
+	assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "helloworld.Greeter")
+	assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "routeguide.RouteGuide")
+	assert.NotContains(t, srv.GRPCServer().GetServiceInfo(), "grpc.reflection.v1.ServerReflection")
+
+	upstream.Stop()
+
+	t.Run("Cache is used when the server is gone", func(t *testing.T) {
+		srv, err := NewServer("", "../../examples/protostubs", WithReflection(target, cache))
+		require.NoError(t, err)
+		assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "routeguide.RouteGuide")
+	})
+
+	t.Run("Cache is a descriptor set", func(t *testing.T) {
+		srv, err := NewServer("", "../../examples/protostubs", WithDescriptorSets(cache))
+		require.NoError(t, err)
+		assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "helloworld.Greeter")
+	})
+}
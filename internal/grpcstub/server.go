@@ -9,21 +9,34 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/journal"
+	"github.com/kogxi/stub-server/internal/metrics"
+	"github.com/kogxi/stub-server/internal/stubfile"
+	"github.com/kogxi/stub-server/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+var errNoProtos = errors.New("neither a proto directory, a descriptor set nor a reflection target is configured")
+
 // Repository defines the interface for storing and retrieving gRPC stubs.
 type Repository interface {
 	Add(stub ProtoStub)
 	Get(service string, method string, in json.RawMessage) (Output, bool)
+	Remove(service string, method string) bool
+	Clear()
 }
 
 // GRPCService represents a gRPC service that can handle requests based on loaded stubs.
@@ -31,49 +44,172 @@ type GRPCService struct {
 	stubs      Repository
 	sdMap      map[string]protoreflect.ServiceDescriptor
 	grpcServer *grpc.Server
+
+	// files and types hold the descriptors loaded by this service only, so
+	// that several services in one process don't share any state.
+	files *protoregistry.Files
+	types *protoregistry.Types
+
+	// loaded holds the stubs loaded from the stub directory, which are
+	// restored by Reset.
+	loaded    []ProtoStub
+	journal   *journal.Journal
+	metrics   *metrics.Metrics
+	tracer    trace.Tracer
+	echo      tracing.Echo
+	accessLog *accesslog.Logger
+
+	delay         time.Duration
+	unmatchedCode codes.Code
 }
 
 // NewServer creates a new gRPC server, loads proto definitions from the
 // specified protoDir, and loads stub definitions from the specified protoStubDir.
-func NewServer(protoDir string, protoStubDir string) (*grpc.Server, error) {
+// WithProtoFS and WithStubFS load them from an fs.FS instead.
+func NewServer(protoDir string, protoStubDir string, opts ...Option) (*GRPCService, error) {
 	server := grpc.NewServer()
-	if err := registerServices(server, protoDir, protoStubDir, NewStorage()); err != nil {
+	s, err := registerServices(server, protoDir, protoStubDir, NewStorage(), newOptions(opts))
+	if err != nil {
 		return nil, fmt.Errorf("register services: %w", err)
 	}
 
-	return server, nil
+	return s, nil
 }
 
 // registerServices loads proto files from the specified protoDir, registers them with the provided
 // gRPC server, and loads stub definitions from the specified stubDir into the provided Repository.
-func registerServices(srv *grpc.Server, protoDir string, stubDir string, r Repository) error {
+func registerServices(srv *grpc.Server, protoDir string, stubDir string, r Repository, o options) (*GRPCService, error) {
+	s, err := newService(srv, protoDir, r, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.stubFS != nil {
+		if err := s.loadStubs(stubfile.LoadFS[ProtoStub](o.stubFS)); err != nil {
+			return nil, fmt.Errorf("load stubs: %w", err)
+		}
+	} else if stubDir != "" {
+		if err := s.loadStubs(stubfile.LoadDir[ProtoStub](stubDir)); err != nil {
+			return nil, fmt.Errorf("load stubs from %v: %w", stubDir, err)
+		}
+	}
+
+	return s, nil
+}
+
+var _ http.Handler = &GRPCService{}
+
+// ServeHTTP serves gRPC requests, which have to use HTTP/2.
+func (s *GRPCService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.grpcServer.ServeHTTP(w, r)
+}
+
+// GRPCServer returns the gRPC server the stubbed services are registered with.
+func (s *GRPCService) GRPCServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// Method returns the descriptor of the method with the given full name in the
+// form "/package.Service/Method", as used in the path of gRPC requests.
+func (s *GRPCService) Method(fullMethod string) (protoreflect.MethodDescriptor, bool) {
+	serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, false
+	}
+
+	service, ok := s.sdMap[serviceName]
+	if !ok {
+		return nil, false
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	return method, method != nil
+}
+
+// Types returns the message and extension types loaded by s.
+func (s *GRPCService) Types() *protoregistry.Types {
+	return s.types
+}
+
+// newService creates a GRPCService and registers the services defined by the
+// proto files in protoDir, the descriptor sets and the reflection target of o
+// with srv.
+func newService(srv *grpc.Server, protoDir string, r Repository, o options) (*GRPCService, error) {
 	s := &GRPCService{
 		stubs:      r,
 		sdMap:      map[string]protoreflect.ServiceDescriptor{},
 		grpcServer: srv,
+		files:      &protoregistry.Files{},
+		types:      &protoregistry.Types{},
+		journal:    o.journal,
+		metrics:    o.metrics,
+		tracer:     tracing.Tracer(o.tracerProvider),
+		echo:       o.echo,
+		accessLog:  o.accessLog,
+
+		delay:         o.delay,
+		unmatchedCode: o.unmatchedCode,
 	}
 
-	if err := s.registerTypes(protoDir); err != nil {
-		return fmt.Errorf("load protos from %v: %w", protoDir, err)
+	if !o.hasProtos(protoDir) {
+		return nil, errNoProtos
 	}
 
-	s.registerServices()
+	if o.protoFS != nil {
+		if err := s.registerTypes(o.protoFS, o.importPaths); err != nil {
+			return nil, fmt.Errorf("load protos: %w", err)
+		}
+	} else if protoDir != "" {
+		if err := s.registerTypes(os.DirFS(protoDir), o.importPaths); err != nil {
+			return nil, fmt.Errorf("load protos from %v: %w", protoDir, err)
+		}
+	}
 
-	if err := s.loadStubs(stubDir); err != nil {
-		return fmt.Errorf("load stubs from %v: %w", stubDir, err)
+	for _, path := range o.descriptorSets {
+		if err := s.registerDescriptorSet(path); err != nil {
+			return nil, fmt.Errorf("load descriptor set: %w", err)
+		}
 	}
 
-	return nil
+	if o.reflectionTarget != "" {
+		if err := s.registerReflection(o.reflectionTarget, o.reflectionCache); err != nil {
+			return nil, fmt.Errorf("load descriptors via reflection: %w", err)
+		}
+	}
+
+	s.registerServices()
+
+	return s, nil
+}
+
+// marshalOptions returns the options to encode messages as JSON. The types of
+// Any fields are resolved from the types loaded by s.
+func (s *GRPCService) marshalOptions() protojson.MarshalOptions {
+	return protojson.MarshalOptions{Resolver: s.types}
+}
+
+// unmarshalOptions returns the options to decode messages from JSON. The
+// types of Any fields are resolved from the types loaded by s.
+func (s *GRPCService) unmarshalOptions() protojson.UnmarshalOptions {
+	return protojson.UnmarshalOptions{Resolver: s.types}
 }
 
 // Handler handles unary gRPC calls by matching them against loaded stubs and returning
 // the corresponding responses.
-func (s *GRPCService) Handler(_ any, ctx context.Context, deccode func(any) error, _ grpc.UnaryServerInterceptor) (interface{}, error) { //nolint:revive
+func (s *GRPCService) Handler(_ any, ctx context.Context, deccode func(any) error, _ grpc.UnaryServerInterceptor) (out interface{}, err error) { //nolint:revive
 	stream := grpc.ServerTransportStreamFromContext(ctx)
 	arr := strings.Split(stream.Method(), "/")
 	serviceName := arr[1]
 	methodName := arr[2]
 
+	c := s.newCall(ctx, serviceName, methodName)
+	defer func() {
+		if err == nil {
+			c.reply(out)
+		}
+		c.finish(err)
+	}()
+
 	slog.InfoContext(ctx, "Received gRPC call", slog.String("service", serviceName), slog.String("method", methodName))
 
 	service, ok := s.sdMap[serviceName]
@@ -92,22 +228,28 @@ func (s *GRPCService) Handler(_ any, ctx context.Context, deccode func(any) erro
 		slog.ErrorContext(ctx, "Failed to decode input message", slog.String("error", err.Error()))
 	}
 
-	jsonInput, err := protojson.Marshal(input)
+	jsonInput, err := s.marshalOptions().Marshal(input)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to marshall input", slog.String("error", err.Error()))
 		return nil, status.Error(codes.InvalidArgument, "Failed to marshall input")
 	}
+	c.message(jsonInput)
 
 	resp, ok := s.stubs.Get(serviceName, methodName, jsonInput)
 	if !ok {
 		slog.ErrorContext(ctx, "No stub configured", slog.String("service", serviceName), slog.String("method", methodName))
-		return nil, status.Error(codes.NotFound, "No stub configured")
+		return nil, status.Error(s.unmatchedCode, "No stub configured")
+	}
+	c.matched()
+
+	if err := c.sleep(ctx, s.delay); err != nil {
+		return nil, err
 	}
 
 	if resp.Data != nil {
 		output := dynamicpb.NewMessage(method.Output())
 
-		err = protojson.Unmarshal(resp.Data, output)
+		err = s.unmarshalOptions().Unmarshal(resp.Data, output)
 		if err != nil {
 			slog.ErrorContext(ctx, "Failed to unmarshal response", slog.String("error", err.Error()))
 			return nil, status.Error(codes.Internal, "Failed to unmarshal response")
@@ -116,6 +258,16 @@ func (s *GRPCService) Handler(_ any, ctx context.Context, deccode func(any) erro
 		return output, nil
 	}
 
+	if resp.Fake != nil {
+		output, err := resp.Fake.message(method.Output(), resp.Fake.newGenerator(), s.types)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate response", slog.String("error", err.Error()))
+			return nil, status.Error(codes.Internal, "Failed to generate response")
+		}
+
+		return output, nil
+	}
+
 	if resp.Code != nil {
 		return nil, status.Error(*resp.Code, resp.Error)
 	}
@@ -125,13 +277,17 @@ func (s *GRPCService) Handler(_ any, ctx context.Context, deccode func(any) erro
 
 // ServerStreamHandler handles server-side streaming gRPC calls by matching them against
 // loaded stubs and returning the corresponding stream of responses.
-func (s *GRPCService) ServerStreamHandler(_ any, stream grpc.ServerStream) error {
+func (s *GRPCService) ServerStreamHandler(_ any, stream grpc.ServerStream) (err error) {
 	ctx := stream.Context()
 	tStream := grpc.ServerTransportStreamFromContext(ctx)
 	arr := strings.Split(tStream.Method(), "/")
 	serviceName := arr[1]
 	methodName := arr[2]
 
+	c := s.newCall(ctx, serviceName, methodName)
+	defer func() { c.finish(err) }()
+	stream = c.stream(stream)
+
 	slog.InfoContext(ctx, "Received server side streaming gRPC call", slog.String("service", serviceName), slog.String("method", methodName))
 
 	service, ok := s.sdMap[serviceName]
@@ -150,23 +306,29 @@ func (s *GRPCService) ServerStreamHandler(_ any, stream grpc.ServerStream) error
 		return status.Error(codes.InvalidArgument, "Failed to receive input message")
 	}
 
-	jsonInput, err := protojson.Marshal(input)
+	jsonInput, err := s.marshalOptions().Marshal(input)
 	if err != nil {
 		slog.Error("Failed to marshall input", slog.String("error", err.Error()))
 		return status.Error(codes.InvalidArgument, "Failed to marshall input")
 	}
+	c.message(jsonInput)
 	slog.InfoContext(ctx, "Received message", slog.String("input", string(jsonInput)))
 
 	resp, ok := s.stubs.Get(serviceName, methodName, jsonInput)
 	if !ok {
 		slog.ErrorContext(ctx, "No stub configured", slog.String("service", serviceName), slog.String("method", methodName))
-		return status.Error(codes.NotFound, "No stub configured")
+		return status.Error(s.unmatchedCode, "No stub configured")
+	}
+	c.matched()
+
+	if err := c.sleep(ctx, s.delay); err != nil {
+		return err
 	}
 
 	if resp.Stream != nil && resp.Stream.Data != nil {
 		for _, d := range resp.Stream.Data {
 			output := dynamicpb.NewMessage(method.Output())
-			if err := protojson.Unmarshal(d, output); err != nil {
+			if err := s.unmarshalOptions().Unmarshal(d, output); err != nil {
 				slog.ErrorContext(ctx, "Failed to unmarshal response", slog.String("error", err.Error()))
 				return status.Error(codes.Internal, "Failed to unmarshal response")
 			}
@@ -176,13 +338,30 @@ func (s *GRPCService) ServerStreamHandler(_ any, stream grpc.ServerStream) error
 				return status.Error(codes.Internal, "Failed to send message")
 			}
 
-			if resp.Stream.Delay > 0 {
-				slog.InfoContext(ctx, "Sleeping", slog.Int("delay_ms", resp.Stream.Delay))
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(time.Duration(resp.Stream.Delay) * time.Millisecond):
-				}
+			if err := c.sleep(ctx, time.Duration(resp.Stream.Delay)*time.Millisecond); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if resp.Stream != nil && resp.Stream.Fake != nil {
+		fake := resp.Stream.Fake
+		gen := fake.newGenerator()
+		for range fake.count() {
+			output, err := fake.message(method.Output(), gen, s.types)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to generate response", slog.String("error", err.Error()))
+				return status.Error(codes.Internal, "Failed to generate response")
+			}
+
+			if err := stream.SendMsg(output); err != nil {
+				slog.ErrorContext(ctx, "Failed to send message", slog.String("error", err.Error()))
+				return status.Error(codes.Internal, "Failed to send message")
+			}
+
+			if err := c.sleep(ctx, time.Duration(resp.Stream.Delay)*time.Millisecond); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -191,15 +370,35 @@ func (s *GRPCService) ServerStreamHandler(_ any, stream grpc.ServerStream) error
 	return nil
 }
 
+// sleep waits for delay, e.g. between two stream messages, unless ctx is done
+// before.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	slog.InfoContext(ctx, "Sleeping", slog.Int64("delay_ms", delay.Milliseconds()))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
 // ClientStreamHandler handles client-side streaming gRPC calls by matching them against
 // loaded stubs and returning the corresponding response after the stream is closed.
-func (s *GRPCService) ClientStreamHandler(_ any, stream grpc.ServerStream) error {
+func (s *GRPCService) ClientStreamHandler(_ any, stream grpc.ServerStream) (err error) {
 	ctx := stream.Context()
 	tStream := grpc.ServerTransportStreamFromContext(ctx)
 	arr := strings.Split(tStream.Method(), "/")
 	serviceName := arr[1]
 	methodName := arr[2]
 
+	c := s.newCall(ctx, serviceName, methodName)
+	defer func() { c.finish(err) }()
+	stream = c.stream(stream)
+
 	slog.InfoContext(ctx, "Received client side streaming gRPC call", slog.String("service", serviceName), slog.String("method", methodName))
 
 	service, ok := s.sdMap[serviceName]
@@ -215,8 +414,9 @@ func (s *GRPCService) ClientStreamHandler(_ any, stream grpc.ServerStream) error
 
 	resp, ok := s.stubs.Get(serviceName, methodName, nil)
 	if !ok {
-		return status.Error(codes.NotFound, "no stub found")
+		return status.Error(s.unmatchedCode, "no stub found")
 	}
+	c.matched()
 
 	for {
 		input := dynamicpb.NewMessage(method.Input())
@@ -233,18 +433,23 @@ func (s *GRPCService) ClientStreamHandler(_ any, stream grpc.ServerStream) error
 			slog.ErrorContext(ctx, "Failed to receive input message", slog.String("error", err.Error()))
 			return status.Error(codes.InvalidArgument, "failed to receive input message")
 		}
-		jsonInput, err := protojson.Marshal(input)
+		jsonInput, err := s.marshalOptions().Marshal(input)
 		if err != nil {
 			slog.Error("Failed to marshall input", slog.String("error", err.Error()))
 			return status.Error(codes.InvalidArgument, "failed to marshall input")
 		}
+		c.message(jsonInput)
 		slog.InfoContext(ctx, "Received message", slog.String("input", string(jsonInput)))
 	}
 
+	if err := c.sleep(ctx, s.delay); err != nil {
+		return err
+	}
+
 	if resp.Data != nil {
 		output := dynamicpb.NewMessage(method.Output())
 
-		if err := protojson.Unmarshal(resp.Data, output); err != nil {
+		if err := s.unmarshalOptions().Unmarshal(resp.Data, output); err != nil {
 			slog.ErrorContext(ctx, "Failed to unmarshal response", slog.String("error", err.Error()))
 			return status.Error(codes.Internal, "failed to unmarshal response")
 		}
@@ -259,6 +464,21 @@ func (s *GRPCService) ClientStreamHandler(_ any, stream grpc.ServerStream) error
 		return nil
 	}
 
+	if resp.Fake != nil {
+		output, err := resp.Fake.message(method.Output(), resp.Fake.newGenerator(), s.types)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to generate response", slog.String("error", err.Error()))
+			return status.Error(codes.Internal, "failed to generate response")
+		}
+
+		if err := stream.SendMsg(output); err != nil {
+			slog.ErrorContext(ctx, "failed to send message", slog.String("error", err.Error()))
+			return status.Error(codes.Internal, "failed to send message")
+		}
+
+		return nil
+	}
+
 	if resp.Code != nil {
 		err := status.Error(*resp.Code, resp.Error)
 		slog.InfoContext(ctx, "Sending error response", slog.String("error", err.Error()))
@@ -0,0 +1,75 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestNewServerIsolation(t *testing.T) {
+	t.Parallel()
+
+	// Several servers created concurrently from the same protos must not
+	// interfere with each other, and each only serves its own services.
+	servers := make([]*GRPCService, 4)
+	eg := new(errgroup.Group)
+	for i := range servers {
+		eg.Go(func() error {
+			srv, err := NewServer("../../examples/protos", "../../examples/protostubs")
+			servers[i] = srv
+			return err
+		})
+	}
+	require.NoError(t, eg.Wait())
+
+	for _, srv := range servers {
+		services := make([]string, 0)
+		for name := range srv.GRPCServer().GetServiceInfo() {
+			services = append(services, name)
+		}
+		slices.Sort(services)
+		assert.Equal(t, []string{"helloworld.Greeter", "routeguide.RouteGuide"}, services)
+	}
+
+	other, err := NewServer("testdata/protos", "testdata/protos", WithImportPaths("testdata/imports"))
+	require.NoError(t, err)
+	assert.Len(t, other.GRPCServer().GetServiceInfo(), 1)
+	assert.Contains(t, other.GRPCServer().GetServiceInfo(), "example.v1.ExampleService")
+}
+
+func TestNewServerReportsProtoErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer("testdata/broken", "testdata/broken")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken.proto:5:8: ")
+	assert.Contains(t, err.Error(), "missing/v1/missing.proto")
+}
+
+func TestNewServerFS(t *testing.T) {
+	t.Parallel()
+
+	protos := fstest.MapFS{
+		"greeter/v1/greeter.proto": {Data: []byte(`syntax = "proto3";
+package greeter.v1;
+import "shared/v1/shared.proto";
+service Greeter {
+  rpc Greet(shared.v1.Money) returns (shared.v1.Money) {}
+}
+`)},
+	}
+	stubs := fstest.MapFS{
+		"greet.yaml": {Data: []byte("service: greeter.v1.Greeter\nmethod: Greet\noutput:\n  data:\n    currency_code: EUR\n")},
+	}
+
+	srv, err := NewServer("", "", WithProtoFS(protos), WithStubFS(stubs), WithImportPaths("testdata/imports"))
+	require.NoError(t, err)
+	assert.Contains(t, srv.GRPCServer().GetServiceInfo(), "greeter.v1.Greeter")
+	_, ok := srv.stubs.Get("greeter.v1.Greeter", "Greet", json.RawMessage(`{}`))
+	assert.True(t, ok)
+}
@@ -46,3 +46,27 @@ func (p *Storage) Get(service string, method string, _ json.RawMessage) (Output,
 
 	return s, true
 }
+
+// Remove removes the stub for the given service and method and reports
+// whether it existed.
+func (p *Storage) Remove(service string, method string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, ok := p.stubs[service][method]; !ok {
+		return false
+	}
+	delete(p.stubs[service], method)
+	if len(p.stubs[service]) == 0 {
+		delete(p.stubs, service)
+	}
+	return true
+}
+
+// Clear removes all stubs.
+func (p *Storage) Clear() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.stubs = map[string]map[string]Output{}
+}
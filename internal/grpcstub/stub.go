@@ -4,40 +4,50 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 
+	"github.com/kogxi/stub-server/internal/stubfile"
 	"google.golang.org/grpc/codes"
 )
 
 // Stream represents a stream of gRPC responses.
 type Stream struct {
 	Data  []json.RawMessage `json:"data"`
-	Error string            `json:"error"`
+	Error string            `json:"error,omitempty"`
 	Code  *codes.Code       `json:"code,omitempty"`
 	Delay int               `json:"delay,omitempty"`
+	Fake  *Fake             `json:"fake,omitempty"`
 }
 
 func (s *Stream) validate() error {
-	if s.Code == nil && len(s.Data) == 0 && s.Error == "" {
+	if s.Code == nil && len(s.Data) == 0 && s.Error == "" && s.Fake == nil {
 		return fmt.Errorf(`stream can't be empty`)
 	}
+	if s.Fake != nil {
+		return s.Fake.validate()
+	}
 	return nil
 }
 
 // Output represents the output of a gRPC method, which can be a single response or a stream.
 type Output struct {
-	Data   json.RawMessage `json:"data"`
-	Error  string          `json:"error"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
 	Code   *codes.Code     `json:"code,omitempty"`
-	Stream *Stream         `json:"stream"`
+	Stream *Stream         `json:"stream,omitempty"`
+	Fake   *Fake           `json:"fake,omitempty"`
 }
 
 func (o *Output) validate() error {
-	if o.Code == nil && o.Data == nil && o.Error == "" && o.Stream == nil {
+	if o.Code == nil && o.Data == nil && o.Error == "" && o.Stream == nil && o.Fake == nil {
 		return fmt.Errorf(`output can't be empty`)
 	}
 
+	if o.Fake != nil {
+		if err := o.Fake.validate(); err != nil {
+			return err
+		}
+	}
+
 	if o.Stream != nil {
 		return o.Stream.validate()
 	}
@@ -48,7 +58,7 @@ func (o *Output) validate() error {
 type ProtoStub struct {
 	Service string `json:"service"`
 	Method  string `json:"method"`
-	Matcher string `json:"matcher"`
+	Matcher string `json:"matcher,omitempty"`
 	Output  Output `json:"output"`
 }
 
@@ -63,67 +73,67 @@ func (s *ProtoStub) validate() error {
 	return s.Output.validate()
 }
 
-func (s *GRPCService) loadStubs(dir string) error {
-	stubs, err := load(dir)
+// loadStubs adds the stubs of entries, which were loaded with err, and keeps
+// them to be restored by Reset.
+func (s *GRPCService) loadStubs(entries []stubfile.Entry[ProtoStub], err error) error {
+	entries, err = validEntries(entries, err)
 	if err != nil {
 		return fmt.Errorf("load stubs: %w", err)
 	}
 
-	for _, stub := range stubs {
-		if s.sdMap[stub.Service] == nil {
-			return fmt.Errorf(`no service "%v" registered`, stub.Service)
+	// Check the payloads against the method descriptors now, so that a
+	// broken stub fails at startup instead of at request time.
+	for _, e := range entries {
+		if errs := s.checkStub(e.Stub); len(errs) > 0 {
+			return e.Errorf("%w", errs[0])
 		}
-		s.stubs.Add(stub)
+		s.loaded = append(s.loaded, e.Stub)
 	}
+	s.Reset()
 
 	return nil
 }
 
-func load(dir string) ([]ProtoStub, error) {
-	stubs := make([]ProtoStub, 0)
-	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			if filepath.Ext(path) != ".json" {
-				return nil
-			}
-
-			stub, err := loadFile(path)
-			if err != nil {
-				return fmt.Errorf("load stub from file %v: %w", path, err)
-			}
-
-			stubs = append(stubs, stub)
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf(`read dir "%v": %w`, dir, err)
+// AddStub validates stub against the loaded proto definitions and adds it,
+// replacing any stub for the same service and method.
+func (s *GRPCService) AddStub(stub ProtoStub) error {
+	if err := stub.validate(); err != nil {
+		return fmt.Errorf("stub validation: %w", err)
+	}
+	if errs := s.checkStub(stub); len(errs) > 0 {
+		return errors.Join(errs...)
 	}
-	return stubs, nil
+	s.stubs.Add(stub)
+	return nil
 }
 
-func loadFile(path string) (s ProtoStub, err error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return ProtoStub{}, fmt.Errorf("open file: %v: %w", path, err)
-	}
-	defer func() {
-		closeErr := f.Close()
-		if closeErr != nil {
-			err = errors.Join(err, fmt.Errorf("close file: %w", closeErr))
-		}
-	}()
+// RemoveStub removes the stub for service and method and reports whether it
+// existed.
+func (s *GRPCService) RemoveStub(service string, method string) bool {
+	return s.stubs.Remove(service, method)
+}
 
-	var stub ProtoStub
-	if err := json.NewDecoder(f).Decode(&stub); err != nil {
-		return ProtoStub{}, fmt.Errorf("unmarshal stub %v: %w", path, err)
+// Reset removes all stubs added with AddStub and restores the stubs loaded
+// from the stub directory.
+func (s *GRPCService) Reset() {
+	s.stubs.Clear()
+	for _, stub := range s.loaded {
+		s.stubs.Add(stub)
 	}
+}
 
-	if err := stub.validate(); err != nil {
-		return ProtoStub{}, fmt.Errorf("stub validation %v: %w", path, err)
+// validEntries returns the valid stubs of entries, which were loaded with
+// err, together with every problem found.
+func validEntries(entries []stubfile.Entry[ProtoStub], err error) ([]stubfile.Entry[ProtoStub], error) {
+	errs := []error{err}
+
+	valid := make([]stubfile.Entry[ProtoStub], 0, len(entries))
+	for _, e := range entries {
+		if err := e.Stub.validate(); err != nil {
+			errs = append(errs, e.Errorf("stub validation: %w", err))
+			continue
+		}
+		valid = append(valid, e)
 	}
-	return stub, nil
+	return valid, errors.Join(errs...)
 }
@@ -0,0 +1,108 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kogxi/stub-server/internal/stubfile"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Validate loads the proto definitions from protoDir and checks every stub in
+// stubDir against them. Instead of stopping at the first problem, all problems
+// found are returned joined together, each pointing to the file and line of
+// the affected stub. WithStubFS checks the stubs of an fs.FS instead of stubDir.
+func Validate(protoDir string, stubDir string, opts ...Option) error {
+	o := newOptions(opts)
+	s, err := newService(grpc.NewServer(), protoDir, NewStorage(), o)
+	if err != nil {
+		return err
+	}
+
+	var entries []stubfile.Entry[ProtoStub]
+	if o.stubFS != nil {
+		entries, err = validEntries(stubfile.LoadFS[ProtoStub](o.stubFS))
+	} else {
+		entries, err = validEntries(stubfile.LoadDir[ProtoStub](stubDir))
+	}
+	errs := []error{err}
+	for _, e := range entries {
+		for _, err := range s.checkStub(e.Stub) {
+			errs = append(errs, e.Errorf("%w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkStub checks a stub against the descriptor of the method it stubs. It
+// verifies that the method exists, that the output has the right shape for
+// the streaming type of the method and that all payloads are valid messages
+// of the method's output type.
+func (s *GRPCService) checkStub(stub ProtoStub) []error {
+	service, ok := s.sdMap[stub.Service]
+	if !ok {
+		return []error{fmt.Errorf(`no service "%v" registered`, stub.Service)}
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(stub.Method))
+	if method == nil {
+		return []error{fmt.Errorf(`service "%v" has no method "%v"`, stub.Service, stub.Method)}
+	}
+
+	var errs []error
+	out := stub.Output
+	if method.IsStreamingServer() {
+		if out.Data != nil {
+			errs = append(errs, fmt.Errorf(`method "%v" is server streaming: use "stream.data" instead of "data"`, stub.Method))
+		}
+		if out.Fake != nil {
+			errs = append(errs, fmt.Errorf(`method "%v" is server streaming: use "stream.fake" instead of "fake"`, stub.Method))
+		}
+	} else if out.Stream != nil {
+		errs = append(errs, fmt.Errorf(`method "%v" is not server streaming: use "data" instead of "stream"`, stub.Method))
+	}
+
+	if out.Data != nil {
+		if err := s.checkPayload(method.Output(), out.Data); err != nil {
+			errs = append(errs, fmt.Errorf(`"data": %w`, err))
+		}
+	}
+	if out.Fake != nil {
+		if err := s.checkFake(method.Output(), out.Fake); err != nil {
+			errs = append(errs, fmt.Errorf(`"fake": %w`, err))
+		}
+	}
+	if out.Stream != nil {
+		for i, d := range out.Stream.Data {
+			if err := s.checkPayload(method.Output(), d); err != nil {
+				errs = append(errs, fmt.Errorf(`"stream.data[%d]": %w`, i, err))
+			}
+		}
+		if out.Stream.Fake != nil {
+			if err := s.checkFake(method.Output(), out.Stream.Fake); err != nil {
+				errs = append(errs, fmt.Errorf(`"stream.fake": %w`, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (s *GRPCService) checkPayload(md protoreflect.MessageDescriptor, data json.RawMessage) error {
+	if err := s.unmarshalOptions().Unmarshal(data, dynamicpb.NewMessage(md)); err != nil {
+		return fmt.Errorf("invalid %v: %w", md.FullName(), err)
+	}
+	return nil
+}
+
+// checkFake generates a message to check that the overrides of f fit md.
+func (s *GRPCService) checkFake(md protoreflect.MessageDescriptor, f *Fake) error {
+	if _, err := f.message(md, f.newGenerator(), s.types); err != nil {
+		return fmt.Errorf("invalid %v: %w", md.FullName(), err)
+	}
+	return nil
+}
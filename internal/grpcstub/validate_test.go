@@ -0,0 +1,53 @@
+package grpcstub
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Valid stubs", func(t *testing.T) {
+		t.Parallel()
+
+		require.NoError(t, Validate("../../examples/protos", "../../examples/protostubs"))
+	})
+
+	t.Run("Invalid stubs", func(t *testing.T) {
+		t.Parallel()
+
+		err := Validate("../../examples/protos", "testdata/invalid")
+		require.Error(t, err)
+
+		// Errors of the protobuf module are deliberately unstable, so only
+		// the position and the start of each problem is compared.
+		problems := strings.Split(err.Error(), "\n")
+		want := []string{
+			`testdata/invalid/a.yaml:1: stub 0: method "ListFeatures" is server streaming`,
+			`testdata/invalid/a.yaml:7: stub 1: service "routeguide.RouteGuide" has no method "Nope"`,
+			`testdata/invalid/a.yaml:11: stub 2: "data": invalid helloworld.HelloReply`,
+			`testdata/invalid/b.json:2: stub 0: method "GetFeature" is not server streaming`,
+			`testdata/invalid/b.json:2: stub 0: "stream.data[0]": invalid routeguide.Feature`,
+			`testdata/invalid/b.json:3: stub 1: stub validation: "service" field is required`,
+		}
+		require.Len(t, problems, len(want))
+		for _, w := range want {
+			assert.True(t, slices.ContainsFunc(problems, func(p string) bool {
+				return strings.HasPrefix(p, w)
+			}), "missing problem %q in %q", w, problems)
+		}
+	})
+}
+
+func TestNewServerRejectsInvalidStubs(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer("../../examples/protos", "testdata/invalid")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/invalid/")
+}
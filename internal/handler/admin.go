@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/kogxi/stub-server/internal/httpstub"
+	"github.com/kogxi/stub-server/internal/journal"
+)
+
+// adminPrefix is the path prefix of the admin API.
+const adminPrefix = "/__admin/"
+
+var (
+	errNoHTTPStubs = errors.New("no HTTP stub server configured")
+	errNoGRPCStubs = errors.New("no gRPC stub server configured")
+)
+
+// adminHandler returns the handler of the admin API, which manages the stubs
+// and the journal at runtime:
+//
+//	POST   /__admin/stubs/http  add or replace an HTTP stub
+//	DELETE /__admin/stubs/http  remove the HTTP stub of the path and method query parameters
+//	POST   /__admin/stubs/grpc  add or replace a gRPC stub
+//	DELETE /__admin/stubs/grpc  remove the gRPC stub of the service and method query parameters
+//	GET    /__admin/journal     list the recorded requests
+//	DELETE /__admin/journal     clear the recorded requests
+//	POST   /__admin/reset       restore the stubs loaded from files and clear the journal
+//
+// The journal can be filtered with the protocol, method, path and service
+// query parameters.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+adminPrefix+"stubs/http", s.addHTTPStub)
+	mux.HandleFunc("DELETE "+adminPrefix+"stubs/http", s.removeHTTPStub)
+	mux.HandleFunc("POST "+adminPrefix+"stubs/grpc", s.addGRPCStub)
+	mux.HandleFunc("DELETE "+adminPrefix+"stubs/grpc", s.removeGRPCStub)
+	mux.HandleFunc("GET "+adminPrefix+"journal", s.listJournal)
+	mux.HandleFunc("DELETE "+adminPrefix+"journal", s.clearJournal)
+	mux.HandleFunc("POST "+adminPrefix+"reset", s.reset)
+	return mux
+}
+
+func (s *Server) addHTTPStub(w http.ResponseWriter, r *http.Request) {
+	if s.httpHandler == nil {
+		writeAdminError(w, http.StatusNotImplemented, errNoHTTPStubs)
+		return
+	}
+
+	var stub httpstub.Stub
+	if err := decodeAdminRequest(r, &stub); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.httpHandler.AddStub(stub); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Added HTTP stub", slog.String("path", stub.Path), slog.String("method", stub.Method))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) removeHTTPStub(w http.ResponseWriter, r *http.Request) {
+	if s.httpHandler == nil {
+		writeAdminError(w, http.StatusNotImplemented, errNoHTTPStubs)
+		return
+	}
+
+	path, method := r.URL.Query().Get("path"), r.URL.Query().Get("method")
+	if path == "" || method == "" {
+		writeAdminError(w, http.StatusBadRequest, errors.New(`"path" and "method" query parameters are required`))
+		return
+	}
+	if !s.httpHandler.RemoveStub(path, method) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("no HTTP stub for %s %s", method, path))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Removed HTTP stub", slog.String("path", path), slog.String("method", method))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addGRPCStub(w http.ResponseWriter, r *http.Request) {
+	if s.grpcServer == nil {
+		writeAdminError(w, http.StatusNotImplemented, errNoGRPCStubs)
+		return
+	}
+
+	var stub grpcstub.ProtoStub
+	if err := decodeAdminRequest(r, &stub); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.grpcServer.AddStub(stub); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Added gRPC stub", slog.String("service", stub.Service), slog.String("method", stub.Method))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) removeGRPCStub(w http.ResponseWriter, r *http.Request) {
+	if s.grpcServer == nil {
+		writeAdminError(w, http.StatusNotImplemented, errNoGRPCStubs)
+		return
+	}
+
+	service, method := r.URL.Query().Get("service"), r.URL.Query().Get("method")
+	if service == "" || method == "" {
+		writeAdminError(w, http.StatusBadRequest, errors.New(`"service" and "method" query parameters are required`))
+		return
+	}
+	if !s.grpcServer.RemoveStub(service, method) {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("no gRPC stub for %s/%s", service, method))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Removed gRPC stub", slog.String("service", service), slog.String("method", method))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listJournal(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	entries := s.journal.Entries(journal.Filter{
+		Protocol: query.Get("protocol"),
+		Method:   query.Get("method"),
+		Path:     query.Get("path"),
+		Service:  query.Get("service"),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to encode journal", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) clearJournal(w http.ResponseWriter, _ *http.Request) {
+	s.journal.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	if s.httpHandler != nil {
+		s.httpHandler.Reset()
+	}
+	if s.grpcServer != nil {
+		s.grpcServer.Reset()
+	}
+	s.journal.Reset()
+
+	slog.InfoContext(r.Context(), "Reset stubs and journal")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeAdminRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("decode stub: %w", err)
+	}
+	return nil
+}
+
+func writeAdminError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
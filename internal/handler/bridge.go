@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcResult is the outcome of a call to the gRPC server.
+type grpcResult struct {
+	// header holds the metadata the server sent before the messages.
+	header http.Header
+	// trailer holds the metadata the server sent after the messages.
+	trailer http.Header
+	status  *status.Status
+}
+
+// callGRPC serves a gRPC request for method with next, which is used by the
+// protocols translated to gRPC. messages are the binary encoded request
+// messages and header is forwarded as request metadata. onMessage is called
+// with the response header and each binary encoded response message as soon
+// as it is written by the server.
+func callGRPC(
+	ctx context.Context,
+	next http.Handler,
+	method string,
+	header http.Header,
+	messages [][]byte,
+	onMessage func(header http.Header, msg []byte) error,
+) (*grpcResult, error) {
+	var body []byte
+	for _, msg := range messages {
+		body = appendFrame(body, 0, msg)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, method, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create gRPC request: %w", err)
+	}
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.RequestURI = method
+	for k, v := range header {
+		if isReservedRequestHeader(k) {
+			continue
+		}
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+
+	rec := &grpcRecorder{header: http.Header{}, onMessage: onMessage}
+	next.ServeHTTP(rec, req)
+	if rec.err != nil {
+		return nil, rec.err
+	}
+
+	result := &grpcResult{
+		header:  rec.metadata(),
+		trailer: rec.trailer(),
+	}
+	result.status, err = rec.status()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// callUnary calls the unary method with the request message data encoded with
+// codec and returns the response message encoded with codec. Problems
+// converting the messages are reported as the status of the result.
+func callUnary(
+	r *http.Request,
+	s *grpcstub.GRPCService,
+	method protoreflect.MethodDescriptor,
+	codec messageCodec,
+	data []byte,
+	header http.Header,
+) ([]byte, *grpcResult) {
+	msg, err := codec.toProto(method.Input(), data)
+	if err != nil {
+		return nil, &grpcResult{status: status.New(codes.InvalidArgument, err.Error())}
+	}
+
+	var reply []byte
+	fullMethod := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	result, err := callGRPC(r.Context(), s, fullMethod, header, [][]byte{msg},
+		func(_ http.Header, msg []byte) error {
+			reply = msg
+			return nil
+		})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to call gRPC method", slog.String("method", fullMethod), slog.String("error", err.Error()))
+		return nil, &grpcResult{status: status.New(codes.Internal, err.Error())}
+	}
+	if result.status.Code() != codes.OK {
+		return nil, result
+	}
+	if reply == nil {
+		result.status = status.New(codes.Internal, "missing response message")
+		return nil, result
+	}
+
+	out, err := codec.fromProto(method.Output(), reply)
+	if err != nil {
+		result.status = status.New(codes.Internal, err.Error())
+		return nil, result
+	}
+	return out, result
+}
+
+// isReservedRequestHeader reports whether k describes the encoding of the
+// translated request rather than metadata for the gRPC server.
+func isReservedRequestHeader(k string) bool {
+	switch k {
+	case "Content-Type", "Content-Length", "Content-Encoding", "Accept-Encoding", "Accept", "Connection", "Te":
+		return true
+	}
+	if k == "Grpc-Timeout" {
+		return false
+	}
+	return strings.HasPrefix(k, "Grpc-") || strings.HasPrefix(k, "Connect-")
+}
+
+func appendFrame(dst []byte, flags byte, msg []byte) []byte {
+	dst = append(dst, flags)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(msg)))
+	return append(dst, msg...)
+}
+
+// grpcRecorder records the response of the gRPC server and splits its body
+// into messages.
+type grpcRecorder struct {
+	header    http.Header
+	sent      http.Header
+	buf       []byte
+	onMessage func(header http.Header, msg []byte) error
+	err       error
+}
+
+var _ http.Flusher = &grpcRecorder{}
+
+func (g *grpcRecorder) Header() http.Header {
+	return g.header
+}
+
+func (g *grpcRecorder) WriteHeader(int) {
+	if g.sent == nil {
+		g.sent = g.header.Clone()
+	}
+}
+
+func (g *grpcRecorder) Write(b []byte) (int, error) {
+	g.WriteHeader(http.StatusOK)
+	g.buf = append(g.buf, b...)
+	for len(g.buf) >= 5 {
+		size := int(binary.BigEndian.Uint32(g.buf[1:5]))
+		if len(g.buf) < 5+size {
+			break
+		}
+		msg := g.buf[5 : 5+size]
+		g.buf = g.buf[5+size:]
+		if g.err == nil {
+			g.err = g.onMessage(g.metadata(), msg)
+		}
+	}
+	return len(b), nil
+}
+
+func (g *grpcRecorder) Flush() {
+	g.WriteHeader(http.StatusOK)
+}
+
+// metadata returns the header metadata sent by the server.
+func (g *grpcRecorder) metadata() http.Header {
+	md := http.Header{}
+	for k, v := range g.sent {
+		if isReservedResponseHeader(k) {
+			continue
+		}
+		md[k] = v
+	}
+	return md
+}
+
+// trailer returns the trailer metadata set by the server after the header was
+// sent, following the http.ResponseWriter contract.
+func (g *grpcRecorder) trailer() http.Header {
+	declared := map[string]bool{}
+	for _, v := range g.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+
+	trailer := http.Header{}
+	for k, v := range g.header {
+		name, isTrailer := strings.CutPrefix(k, http.TrailerPrefix)
+		if (!isTrailer && !declared[k]) || isReservedResponseHeader(http.CanonicalHeaderKey(name)) {
+			continue
+		}
+		trailer[http.CanonicalHeaderKey(name)] = v
+	}
+	return trailer
+}
+
+func isReservedResponseHeader(k string) bool {
+	switch k {
+	case "Content-Type", "Content-Length", "Trailer", "Date":
+		return true
+	}
+	return strings.HasPrefix(k, "Grpc-") || strings.HasPrefix(k, http.TrailerPrefix)
+}
+
+// status returns the status of the call sent in the trailers.
+func (g *grpcRecorder) status() (*status.Status, error) {
+	if details := g.header.Get("Grpc-Status-Details-Bin"); details != "" {
+		data, err := decodeBinaryHeader(details)
+		if err != nil {
+			return nil, fmt.Errorf("decode status details: %w", err)
+		}
+		var st spb.Status
+		if err := proto.Unmarshal(data, &st); err != nil {
+			return nil, fmt.Errorf("unmarshal status details: %w", err)
+		}
+		return status.FromProto(&st), nil
+	}
+
+	code, err := strconv.ParseUint(g.header.Get("Grpc-Status"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC status %q", g.header.Get("Grpc-Status"))
+	}
+	msg, err := url.PathUnescape(g.header.Get("Grpc-Message"))
+	if err != nil {
+		msg = g.header.Get("Grpc-Message")
+	}
+	return status.New(codes.Code(code), msg), nil
+}
+
+func decodeBinaryHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// messageCodec converts messages between JSON or binary protobuf and the
+// binary protobuf encoding used by the gRPC server.
+type messageCodec struct {
+	json  bool
+	types *protoregistry.Types
+}
+
+// toProto converts a request message of type md to binary protobuf.
+func (c messageCodec) toProto(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	if !c.json {
+		return data, nil
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := (protojson.UnmarshalOptions{Resolver: c.types}).Unmarshal(data, msg); err != nil {
+			return nil, fmt.Errorf("unmarshal %s: %w", md.FullName(), err)
+		}
+	}
+	return proto.Marshal(msg)
+}
+
+// fromProto converts a binary protobuf response message of type md.
+func (c messageCodec) fromProto(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	if !c.json {
+		return data, nil
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{Resolver: c.types}).Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", md.FullName(), err)
+	}
+	return protojson.MarshalOptions{Resolver: c.types}.Marshal(msg)
+}
+
+func readBody(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	connectStreamContentType = "application/connect+"
+
+	// connectCompressedFlag marks a compressed message envelope.
+	connectCompressedFlag = 0x01
+	// connectEndStreamFlag marks the last envelope of a streaming response,
+	// holding the status and trailers as JSON.
+	connectEndStreamFlag = 0x02
+)
+
+type connectCode struct {
+	name       string
+	httpStatus int
+}
+
+// connectCodes maps gRPC status codes to their names and HTTP status codes in
+// the Connect protocol.
+var connectCodes = map[codes.Code]connectCode{
+	codes.Canceled:           {"canceled", 499},
+	codes.Unknown:            {"unknown", http.StatusInternalServerError},
+	codes.InvalidArgument:    {"invalid_argument", http.StatusBadRequest},
+	codes.DeadlineExceeded:   {"deadline_exceeded", http.StatusGatewayTimeout},
+	codes.NotFound:           {"not_found", http.StatusNotFound},
+	codes.AlreadyExists:      {"already_exists", http.StatusConflict},
+	codes.PermissionDenied:   {"permission_denied", http.StatusForbidden},
+	codes.ResourceExhausted:  {"resource_exhausted", http.StatusTooManyRequests},
+	codes.FailedPrecondition: {"failed_precondition", http.StatusBadRequest},
+	codes.Aborted:            {"aborted", http.StatusConflict},
+	codes.OutOfRange:         {"out_of_range", http.StatusBadRequest},
+	codes.Unimplemented:      {"unimplemented", http.StatusNotImplemented},
+	codes.Internal:           {"internal", http.StatusInternalServerError},
+	codes.Unavailable:        {"unavailable", http.StatusServiceUnavailable},
+	codes.DataLoss:           {"data_loss", http.StatusInternalServerError},
+	codes.Unauthenticated:    {"unauthenticated", http.StatusUnauthorized},
+}
+
+// connectError is the JSON representation of an error in the Connect
+// protocol.
+type connectError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Details []connectDetail `json:"details,omitempty"`
+}
+
+type connectDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// connectEndStream is the JSON payload of the last envelope of a streaming
+// response.
+type connectEndStream struct {
+	Error    *connectError `json:"error,omitempty"`
+	Metadata http.Header   `json:"metadata,omitempty"`
+}
+
+func connectCodeOf(st *status.Status) connectCode {
+	code, ok := connectCodes[st.Code()]
+	if !ok {
+		return connectCodes[codes.Unknown]
+	}
+	return code
+}
+
+func newConnectError(st *status.Status) *connectError {
+	e := &connectError{Code: connectCodeOf(st).name, Message: st.Message()}
+	for _, detail := range st.Proto().GetDetails() {
+		typeName := detail.GetTypeUrl()
+		if i := strings.LastIndexByte(typeName, '/'); i >= 0 {
+			typeName = typeName[i+1:]
+		}
+		e.Details = append(e.Details, connectDetail{
+			Type:  typeName,
+			Value: base64.RawStdEncoding.EncodeToString(detail.GetValue()),
+		})
+	}
+	return e
+}
+
+// isConnect reports whether r uses the Connect protocol. Streaming requests
+// are identified by their content type, unary requests by the
+// Connect-Protocol-Version header or, for GET requests, the query parameter.
+func isConnect(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), connectStreamContentType) {
+		return true
+	}
+	if r.Header.Get("Connect-Protocol-Version") != "" {
+		return true
+	}
+	return r.Method == http.MethodGet && r.URL.Query().Get("connect") == "v1"
+}
+
+// serveConnect translates a Connect request into a gRPC request for the
+// method in the request path and translates the response back.
+func serveConnect(s *grpcstub.GRPCService, w http.ResponseWriter, r *http.Request) {
+	method, ok := s.Method(r.URL.Path)
+	if !ok {
+		writeConnectError(w, status.Newf(codes.Unimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), connectStreamContentType) {
+		serveConnectStream(s, method, w, r)
+		return
+	}
+	serveConnectUnary(s, method, w, r)
+}
+
+func serveConnectUnary(s *grpcstub.GRPCService, method protoreflect.MethodDescriptor, w http.ResponseWriter, r *http.Request) {
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		http.Error(w, "streaming methods require the application/connect+ content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var contentType string
+	var data []byte
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		contentType = "application/" + query.Get("encoding")
+		data = []byte(query.Get("message"))
+		if query.Get("base64") == "1" {
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(data), "="))
+			if err != nil {
+				writeConnectError(w, status.Newf(codes.InvalidArgument, "decode message: %v", err))
+				return
+			}
+			data = decoded
+		}
+	case http.MethodPost:
+		contentType = r.Header.Get("Content-Type")
+		body, err := readBody(r.Body)
+		if err != nil {
+			writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+		data = body
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	codec, ok := connectCodec(s, contentType, "application/")
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !checkConnectEncoding(w, r.Header.Get("Content-Encoding")) {
+		return
+	}
+
+	out, result := callUnary(r, s, method, codec, data, connectHeader(r))
+
+	h := w.Header()
+	for k, v := range result.header {
+		h[k] = v
+	}
+	for k, v := range result.trailer {
+		h["Trailer-"+k] = v
+	}
+
+	if result.status.Code() != codes.OK {
+		writeConnectError(w, result.status)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	if _, err := w.Write(out); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write Connect response", slog.String("error", err.Error()))
+	}
+}
+
+func serveConnectStream(s *grpcstub.GRPCService, method protoreflect.MethodDescriptor, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	codec, ok := connectCodec(s, contentType, connectStreamContentType)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !checkConnectEncoding(w, r.Header.Get("Connect-Content-Encoding")) {
+		return
+	}
+
+	body, err := readBody(r.Body)
+	if err != nil {
+		writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 || len(body) < 5+int(binary.BigEndian.Uint32(body[1:5])) {
+			writeConnectError(w, status.New(codes.InvalidArgument, "truncated message envelope"))
+			return
+		}
+		flags, size := body[0], int(binary.BigEndian.Uint32(body[1:5]))
+		if flags&connectCompressedFlag != 0 {
+			writeConnectError(w, status.New(codes.Unimplemented, "compressed messages are not supported"))
+			return
+		}
+		msg, err := codec.toProto(method.Input(), body[5:5+size])
+		if err != nil {
+			writeConnectError(w, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+		messages = append(messages, msg)
+		body = body[5+size:]
+	}
+
+	wroteHeader := false
+	writeHeader := func(header http.Header) {
+		wroteHeader = true
+		h := w.Header()
+		for k, v := range header {
+			h[k] = v
+		}
+		h.Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	result, err := callGRPC(r.Context(), s, r.URL.Path, connectHeader(r), messages,
+		func(header http.Header, msg []byte) error {
+			if !wroteHeader {
+				writeHeader(header)
+			}
+			out, err := codec.fromProto(method.Output(), msg)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(appendFrame(nil, 0, out)); err != nil {
+				return fmt.Errorf("write message: %w", err)
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to serve Connect stream", slog.String("error", err.Error()))
+		result = &grpcResult{status: status.New(codes.Internal, err.Error())}
+	}
+	if !wroteHeader {
+		writeHeader(result.header)
+	}
+
+	end := connectEndStream{Metadata: result.trailer}
+	if result.status.Code() != codes.OK {
+		end.Error = newConnectError(result.status)
+	}
+	data, err := json.Marshal(end)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to marshal Connect end of stream", slog.String("error", err.Error()))
+		return
+	}
+	if _, err := w.Write(appendFrame(nil, connectEndStreamFlag, data)); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write Connect end of stream", slog.String("error", err.Error()))
+	}
+}
+
+// connectCodec returns the codec for the media type of contentType, which has
+// to start with prefix followed by "json" or "proto".
+func connectCodec(s *grpcstub.GRPCService, contentType string, prefix string) (messageCodec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return messageCodec{}, false
+	}
+
+	switch strings.TrimPrefix(mediaType, prefix) {
+	case "json":
+		return messageCodec{json: true, types: s.Types()}, strings.HasPrefix(mediaType, prefix)
+	case "proto":
+		return messageCodec{types: s.Types()}, strings.HasPrefix(mediaType, prefix)
+	default:
+		return messageCodec{}, false
+	}
+}
+
+// checkConnectEncoding writes an error if the request is compressed.
+func checkConnectEncoding(w http.ResponseWriter, encoding string) bool {
+	if encoding == "" || encoding == "identity" {
+		return true
+	}
+	writeConnectError(w, status.Newf(codes.Unimplemented, "unsupported compression %q", encoding))
+	return false
+}
+
+// connectHeader returns the metadata to forward to the gRPC server, with the
+// Connect timeout translated to a gRPC timeout.
+func connectHeader(r *http.Request) http.Header {
+	header := r.Header.Clone()
+	if timeout := r.Header.Get("Connect-Timeout-Ms"); timeout != "" {
+		header.Set("Grpc-Timeout", timeout+"m")
+	}
+	return header
+}
+
+func writeConnectError(w http.ResponseWriter, st *status.Status) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(newConnectError(st)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(connectCodeOf(st).httpStatus)
+	_, _ = w.Write(body.Bytes())
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame holding the trailers of a gRPC-Web
+	// response.
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebExposedHeaders are the response headers browsers need access to.
+const grpcWebExposedHeaders = "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin"
+
+func isGRPCWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// isGRPCWebPreflight reports whether r is a CORS preflight request of a
+// gRPC-Web client, which always sends the x-grpc-web header.
+func isGRPCWebPreflight(r *http.Request) bool {
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	return httpguts.HeaderValuesContainsToken(r.Header.Values("Access-Control-Request-Headers"), "x-grpc-web")
+}
+
+// serveGRPCWebPreflight allows cross-origin gRPC-Web requests from any origin.
+func serveGRPCWebPreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	setAllowOrigin(h, r)
+	h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", strings.Join(r.Header.Values("Access-Control-Request-Headers"), ", "))
+	h.Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setAllowOrigin(h http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	h.Add("Vary", "Origin")
+}
+
+// serveGRPCWeb translates a gRPC-Web request into a gRPC request, serves it
+// with next and translates the response back. Requests with the
+// application/grpc-web-text content type are base64 encoded in both
+// directions.
+func serveGRPCWeb(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+	prefix := grpcWebContentType
+	if text {
+		prefix = grpcWebTextContentType
+	}
+	subtype := strings.TrimPrefix(contentType, prefix)
+
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.Header.Set("Content-Type", "application/grpc"+subtype)
+	req.Header.Del("Content-Length")
+	req.Header.Del("X-Grpc-Web")
+	req.ContentLength = -1
+
+	if text {
+		body, err := decodeGRPCWebText(r.Body)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to decode gRPC-Web text request", slog.String("error", err.Error()))
+			http.Error(w, "invalid base64 request body", http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	setAllowOrigin(w.Header(), r)
+	w.Header().Set("Access-Control-Expose-Headers", grpcWebExposedHeaders)
+
+	gw := &grpcWebResponseWriter{
+		w:           w,
+		header:      http.Header{},
+		text:        text,
+		contentType: prefix + subtype,
+	}
+	next.ServeHTTP(gw, req)
+	gw.finish()
+}
+
+// decodeGRPCWebText decodes a base64 request body. Clients may send several
+// separately padded chunks, so the body is decoded in groups of four
+// characters instead of as a whole.
+func decodeGRPCWebText(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	data = bytes.Join(bytes.Fields(data), nil)
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid base64 length %d", len(data))
+	}
+
+	out := make([]byte, 0, base64.StdEncoding.DecodedLen(len(data)))
+	buf := make([]byte, 3)
+	for i := 0; i < len(data); i += 4 {
+		n, err := base64.StdEncoding.Decode(buf, data[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf[:n]...)
+	}
+	return out, nil
+}
+
+// grpcWebResponseWriter turns the response of the gRPC server into a gRPC-Web
+// response. The gRPC server sets trailers as headers after writing the
+// headers, following the http.ResponseWriter contract; they are sent as a
+// trailer frame at the end of the body instead.
+type grpcWebResponseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	text        bool
+	contentType string
+	wroteHeader bool
+}
+
+var _ http.Flusher = &grpcWebResponseWriter{}
+
+func (g *grpcWebResponseWriter) Header() http.Header {
+	return g.header
+}
+
+func (g *grpcWebResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.w.Header()
+	for k, v := range g.header {
+		if k == "Trailer" || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		h[k] = v
+	}
+	h.Set("Content-Type", g.contentType)
+	h.Del("Content-Length")
+	g.w.WriteHeader(code)
+}
+
+func (g *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	g.WriteHeader(http.StatusOK)
+	if !g.text {
+		return g.w.Write(b)
+	}
+
+	if _, err := g.w.Write([]byte(base64.StdEncoding.EncodeToString(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (g *grpcWebResponseWriter) Flush() {
+	g.WriteHeader(http.StatusOK)
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the trailers collected from the header as the trailer frame.
+func (g *grpcWebResponseWriter) finish() {
+	declared := map[string]bool{}
+	for _, v := range g.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+
+	var trailer bytes.Buffer
+	for k, vv := range g.header {
+		name, isTrailer := strings.CutPrefix(k, http.TrailerPrefix)
+		if !isTrailer && !declared[k] {
+			continue
+		}
+		for _, v := range vv {
+			_, _ = fmt.Fprintf(&trailer, "%s: %s\r\n", strings.ToLower(name), v)
+		}
+	}
+
+	frame := make([]byte, 5, 5+trailer.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailer.Len()))
+	frame = append(frame, trailer.Bytes()...)
+
+	if _, err := g.Write(frame); err != nil {
+		slog.Error("Failed to write gRPC-Web trailers", slog.String("error", err.Error()))
+		return
+	}
+	g.Flush()
+}
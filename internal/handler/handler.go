@@ -4,35 +4,160 @@ package handler
 
 import (
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/kogxi/stub-server/internal/accesslog"
 	"github.com/kogxi/stub-server/internal/grpcstub"
 	"github.com/kogxi/stub-server/internal/httpstub"
+	"github.com/kogxi/stub-server/internal/journal"
+	"github.com/kogxi/stub-server/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
-	"google.golang.org/grpc"
 )
 
 // Server represents a server that can handle both HTTP and gRPC requests.
 type Server struct {
-	grpcServer  *grpc.Server
-	httpHandler http.Handler
+	grpcServer  *grpcstub.GRPCService
+	httpRoutes  []*httpRoute
+	httpHandler *httpstub.Handler
+
+	// admin serves the admin API if it is enabled, journal records the
+	// requests for it.
+	admin   http.Handler
+	journal *journal.Journal
+
+	// metrics collects the metrics served under metricsPath if they are
+	// enabled.
+	metrics *metrics.Metrics
+
+	// accessLog logs the served requests if it is set.
+	accessLog *accesslog.Logger
+
+	// tracerProvider creates the spans of the served requests if it is set.
+	tracerProvider trace.TracerProvider
+
+	// httpStubFS, protoFS and grpcStubFS are loaded instead of the
+	// respective directories if they are set.
+	httpStubFS fs.FS
+	protoFS    fs.FS
+	grpcStubFS fs.FS
+
+	httpOptions   []httpstub.Option
+	grpcOptions   []grpcstub.Option
+	enableAdmin   bool
+	enableMetrics bool
+
+	inFlight requests
 }
 
 var _ http.Handler = &Server{}
 
+// Option configures a Server created by New.
+type Option func(*Server)
+
+// WithHTTPOptions sets the options of the HTTP stub handler.
+func WithHTTPOptions(opts ...httpstub.Option) Option {
+	return func(s *Server) {
+		s.httpOptions = append(s.httpOptions, opts...)
+	}
+}
+
+// WithGRPCOptions sets the options used to load the proto definitions of the
+// gRPC stub server.
+func WithGRPCOptions(opts ...grpcstub.Option) Option {
+	return func(s *Server) {
+		s.grpcOptions = append(s.grpcOptions, opts...)
+	}
+}
+
+// WithHTTPStubFS loads the HTTP stubs from fsys instead of the HTTP stub
+// directory.
+func WithHTTPStubFS(fsys fs.FS) Option {
+	return func(s *Server) {
+		s.httpStubFS = fsys
+	}
+}
+
+// WithProtoFS compiles the .proto files in fsys instead of those in the proto
+// directory.
+func WithProtoFS(fsys fs.FS) Option {
+	return func(s *Server) {
+		s.protoFS = fsys
+	}
+}
+
+// WithGRPCStubFS loads the gRPC stubs from fsys instead of the gRPC stub
+// directory.
+func WithGRPCStubFS(fsys fs.FS) Option {
+	return func(s *Server) {
+		s.grpcStubFS = fsys
+	}
+}
+
+// WithAdmin enables the admin API under /__admin/, which adds and removes
+// stubs at runtime and lists the recorded requests.
+func WithAdmin() Option {
+	return func(s *Server) {
+		s.enableAdmin = true
+	}
+}
+
+// WithMetrics serves Prometheus metrics of the served requests under
+// /metrics.
+func WithMetrics() Option {
+	return func(s *Server) {
+		s.enableMetrics = true
+	}
+}
+
+// WithAccessLog logs every served HTTP request and gRPC call with the headers
+// and bodies of the request and the response, configured by opts.
+func WithAccessLog(opts ...accesslog.Option) Option {
+	return func(s *Server) {
+		s.accessLog = accesslog.New(opts...)
+	}
+}
+
+// WithTracing creates a span with the tracers of tp for every served HTTP
+// request and gRPC call, continuing the trace propagated by the caller.
+func WithTracing(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracerProvider = tp
+	}
+}
+
 // WithProto configures the server to handle gRPC requests using the provided
 // proto and stub directories.
 func (s *Server) WithProto(protoDir string, stubDir string) error {
-	server, err := grpcstub.NewServer(protoDir, stubDir)
+	opts := append([]grpcstub.Option{
+		grpcstub.WithJournal(s.journal),
+		grpcstub.WithMetrics(s.metrics),
+		grpcstub.WithTracerProvider(s.tracerProvider),
+		grpcstub.WithAccessLog(s.accessLog),
+	}, s.grpcOptions...)
+	if s.protoFS != nil {
+		opts = append(opts, grpcstub.WithProtoFS(s.protoFS))
+	}
+	if s.grpcStubFS != nil {
+		opts = append(opts, grpcstub.WithStubFS(s.grpcStubFS))
+	}
+	server, err := grpcstub.NewServer(protoDir, stubDir, opts...)
 	if err != nil {
 		return fmt.Errorf("initialize gRPC server: %w", err)
 	}
 
+	routes, err := newHTTPRoutes(server)
+	if err != nil {
+		return fmt.Errorf("load HTTP rules: %w", err)
+	}
+
 	s.grpcServer = server
+	s.httpRoutes = routes
 
 	return nil
 }
@@ -40,7 +165,19 @@ func (s *Server) WithProto(protoDir string, stubDir string) error {
 // WithHTTP configures the server to handle HTTP requests using the provided
 // HTTP stubs directory.
 func (s *Server) WithHTTP(httpStubs string) error {
-	handler, err := httpstub.NewHandler(httpStubs)
+	opts := append([]httpstub.Option{
+		httpstub.WithJournal(s.journal),
+		httpstub.WithMetrics(s.metrics),
+		httpstub.WithTracerProvider(s.tracerProvider),
+		httpstub.WithAccessLog(s.accessLog),
+	}, s.httpOptions...)
+	var handler *httpstub.Handler
+	var err error
+	if s.httpStubFS != nil {
+		handler, err = httpstub.NewHandlerFS(s.httpStubFS, opts...)
+	} else {
+		handler, err = httpstub.NewHandler(httpStubs, opts...)
+	}
 	if err != nil {
 		return fmt.Errorf("initialize HTTP handler: %w", err)
 	}
@@ -50,29 +187,141 @@ func (s *Server) WithHTTP(httpStubs string) error {
 	return nil
 }
 
+// metricsPath is the path of the Prometheus metrics.
+const metricsPath = "/metrics"
+
+// Service is a set of the requests served by a Server, used to serve them on
+// separate listeners.
+type Service int
+
+const (
+	// ServiceHTTP are the requests served by the HTTP stubs.
+	ServiceHTTP Service = 1 << iota
+	// ServiceGRPC are the calls of the gRPC stubs over gRPC, gRPC-Web,
+	// Connect, REST transcoding and plain HTTP.
+	ServiceGRPC
+	// ServiceAdmin are the requests of the admin API.
+	ServiceAdmin
+	// ServiceMetrics are the requests of the Prometheus metrics.
+	ServiceMetrics
+
+	// ServiceAll are all requests.
+	ServiceAll = ServiceHTTP | ServiceGRPC | ServiceAdmin | ServiceMetrics
+)
+
+// String returns the names of the services in s, e.g. "http,grpc".
+func (s Service) String() string {
+	names := make([]string, 0, 4)
+	for _, svc := range []struct {
+		service Service
+		name    string
+	}{{ServiceHTTP, "http"}, {ServiceGRPC, "grpc"}, {ServiceAdmin, "admin"}, {ServiceMetrics, "metrics"}} {
+		if s&svc.service != 0 {
+			names = append(names, svc.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Handler returns a handler serving only the requests of services. Other
+// requests are answered with 404 Not Found.
+func (s *Server) Handler(services Service) http.Handler {
+	return allowH2c(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, services)
+	}))
+}
+
 // ServeHTTP routes incoming HTTP requests to either the gRPC server or the HTTP
 // handler based on the request properties. If the request is a gRPC
 // request (HTTP/2 with "application/grpc" content type), it is forwarded to the
-// gRPC server. Otherwise, it is handled by the HTTP handler.
+// gRPC server. gRPC-Web requests ("application/grpc-web" content type over any
+// HTTP version) and their CORS preflight requests are translated and served by
+// the gRPC server as well, and so are Connect requests ("application/connect+"
+// content type for streams, Connect-Protocol-Version header for unary calls).
+// Requests matching the google.api.http annotation of a gRPC method are
+// transcoded to that method, and POST requests with a JSON or binary protobuf
+// body to the path of a unary gRPC method call that method. Otherwise, it is
+// handled by the HTTP handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, ServiceAll)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, services Service) {
+	s.inFlight.add()
+	defer s.inFlight.finish()
+
+	if services&ServiceAdmin != 0 && s.admin != nil && strings.HasPrefix(r.URL.Path, adminPrefix) {
+		s.admin.ServeHTTP(w, r)
+		return
+	}
+
+	if services&ServiceMetrics != 0 && s.metrics != nil && r.URL.Path == metricsPath {
+		s.metrics.Handler().ServeHTTP(w, r)
+		return
+	}
+
+	if services&ServiceGRPC != 0 && s.serveGRPC(w, r) {
+		return
+	}
+
+	if services&ServiceHTTP == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.httpHandler == nil {
+		slog.ErrorContext(r.Context(), "No HTTP stub server configured")
+		http.Error(w, "No HTTP stub server configured", http.StatusNotImplemented)
+		return
+	}
+
+	s.httpHandler.ServeHTTP(w, r)
+}
+
+// serveGRPC serves r if it is a call of a gRPC stub and reports whether it
+// did.
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) bool {
+	if isGRPCWeb(r) || isGRPCWebPreflight(r) {
+		if s.grpcServer == nil {
+			slog.ErrorContext(r.Context(), "No gRPC stub server configured")
+			http.Error(w, "No gRPC stub server configured", http.StatusNotImplemented)
+			return true
+		}
+		if r.Method == http.MethodOptions {
+			serveGRPCWebPreflight(w, r)
+			return true
+		}
+		serveGRPCWeb(s.grpcServer, w, r)
+		return true
+	}
+
 	if r.ProtoMajor == 2 && strings.HasPrefix(
 		r.Header.Get("Content-Type"), "application/grpc") {
 		if s.grpcServer == nil {
 			slog.ErrorContext(r.Context(), "No gRPC stub server configured")
 			http.Error(w, "No gRPC stub server configured", http.StatusNotImplemented)
-			return
+			return true
 		}
 		s.grpcServer.ServeHTTP(w, r)
-		return
+		return true
 	}
 
-	if s.httpHandler == nil {
-		slog.ErrorContext(r.Context(), "No HTTP stub server configured")
-		http.Error(w, "No HTTP stub server configured", http.StatusNotImplemented)
-		return
+	if isConnect(r) {
+		if s.grpcServer == nil {
+			slog.ErrorContext(r.Context(), "No gRPC stub server configured")
+			http.Error(w, "No gRPC stub server configured", http.StatusNotImplemented)
+			return true
+		}
+		serveConnect(s.grpcServer, w, r)
+		return true
 	}
 
-	s.httpHandler.ServeHTTP(w, r)
+	if route, values, ok := matchHTTPRoute(s.httpRoutes, r); ok {
+		serveTranscoded(s.grpcServer, route, values, w, r)
+		return true
+	}
+
+	return s.grpcServer != nil && isPlainRPC(r) && servePlainRPC(s.grpcServer, w, r)
 }
 
 func allowH2c(next http.Handler) http.Handler {
@@ -82,25 +331,50 @@ func allowH2c(next http.Handler) http.Handler {
 
 // New creates a new Server instance and configures it based on the provided
 // directories for HTTP stubs, proto files, and gRPC stubs. If the respective
-// directory is an empty string, that type of handling is not configured.
-func New(httpStubDir string, protoDir string, protoStubDir string) (http.Handler, error) {
-	mux := http.NewServeMux()
+// stub directory is an empty string and no fs.FS is set for it, that type of
+// handling is not configured, unless the admin API is enabled to add stubs at
+// runtime. protoDir may be empty if the proto definitions are loaded from
+// WithProtoFS or from descriptor sets configured with WithGRPCOptions. The
+// returned handler serves all requests.
+func New(httpStubDir string, protoDir string, protoStubDir string, opts ...Option) (http.Handler, error) {
+	s, err := NewServer(httpStubDir, protoDir, protoStubDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s.Handler(ServiceAll), nil
+}
 
+// NewServer is like New but returns the Server, whose Handler serves the
+// services on separate listeners.
+func NewServer(httpStubDir string, protoDir string, protoStubDir string, opts ...Option) (*Server, error) {
 	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
 
-	mux.Handle("/", s)
+	if s.enableAdmin {
+		s.journal = journal.New()
+		s.admin = s.adminHandler()
+	}
+	if s.enableMetrics {
+		s.metrics = metrics.New()
+	}
 
-	if httpStubDir != "" {
+	// With the admin API, HTTP stubs can be added to a server started
+	// without any.
+	if httpStubDir != "" || s.httpStubFS != nil || s.enableAdmin {
 		if err := s.WithHTTP(httpStubDir); err != nil {
 			return nil, fmt.Errorf("create HTTP handler: %w", err)
 		}
 	}
 
-	if protoDir != "" && protoStubDir != "" {
+	hasStubs := protoStubDir != "" || s.grpcStubFS != nil
+	hasProtos := s.protoFS != nil || grpcstub.HasProtos(protoDir, s.grpcOptions...)
+	if hasStubs || (s.enableAdmin && hasProtos) {
 		if err := s.WithProto(protoDir, protoStubDir); err != nil {
 			return nil, fmt.Errorf("create gRPC handler: %w", err)
 		}
 	}
 
-	return allowH2c(s), nil
+	return s, nil
 }
@@ -1,7 +1,11 @@
 package handler_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,16 +14,32 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/grpcstub"
 	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/kogxi/stub-server/internal/httpstub"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
 	routeguide "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 var serverURL string
@@ -174,3 +194,639 @@ func TestGrpcServerSuccessResponses(t *testing.T) {
 		assert.Equal(t, int32(120), summary.ElapsedTime)
 	})
 }
+
+// grpcWebCall sends msg to method as a gRPC-Web request and returns the
+// response messages and trailers.
+func grpcWebCall(t *testing.T, contentType string, method string, msg proto.Message) ([][]byte, http.Header) {
+	t.Helper()
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(data)))
+	body = append(body, data...)
+
+	text := strings.HasPrefix(contentType, "application/grpc-web-text")
+	if text {
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, serverURL+method, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if text {
+		// Every write of the server is padded separately.
+		decoded := make([]byte, 0)
+		for len(respBody) > 0 {
+			n := bytes.IndexByte(respBody, '=')
+			if n < 0 {
+				n = len(respBody)
+			} else {
+				for n < len(respBody) && respBody[n] == '=' {
+					n++
+				}
+			}
+			chunk, err := base64.StdEncoding.DecodeString(string(respBody[:n]))
+			require.NoError(t, err)
+			decoded = append(decoded, chunk...)
+			respBody = respBody[n:]
+		}
+		respBody = decoded
+	}
+
+	messages := make([][]byte, 0)
+	trailer := http.Header{}
+	for len(respBody) > 0 {
+		require.GreaterOrEqual(t, len(respBody), 5)
+		flag, size := respBody[0], binary.BigEndian.Uint32(respBody[1:5])
+		frame := respBody[5 : 5+size]
+		respBody = respBody[5+size:]
+
+		if flag&0x80 == 0 {
+			messages = append(messages, frame)
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(frame)), "\r\n") {
+			k, v, _ := strings.Cut(line, ": ")
+			trailer.Add(k, v)
+		}
+	}
+	return messages, trailer
+}
+
+func TestGrpcWeb(t *testing.T) {
+	t.Parallel()
+
+	for _, contentType := range []string{"application/grpc-web+proto", "application/grpc-web-text"} {
+		t.Run("Unary call "+contentType, func(t *testing.T) {
+			t.Parallel()
+
+			messages, trailer := grpcWebCall(t, contentType, "/helloworld.Greeter/SayHello", &helloworldpb.HelloRequest{Name: "Jane"})
+			require.Len(t, messages, 1)
+			assert.Equal(t, "0", trailer.Get("grpc-status"))
+
+			var reply helloworldpb.HelloReply
+			require.NoError(t, proto.Unmarshal(messages[0], &reply))
+			assert.Equal(t, "Hello from proto stub", reply.Message)
+		})
+	}
+
+	t.Run("Server side streaming", func(t *testing.T) {
+		t.Parallel()
+
+		messages, trailer := grpcWebCall(t, "application/grpc-web-text+proto", "/routeguide.RouteGuide/ListFeatures", &routeguide.Rectangle{})
+		require.Len(t, messages, 3)
+		assert.Equal(t, "0", trailer.Get("grpc-status"))
+
+		var feature routeguide.Feature
+		require.NoError(t, proto.Unmarshal(messages[2], &feature))
+		assert.Equal(t, "#3", feature.Name)
+	})
+
+	t.Run("Error status", func(t *testing.T) {
+		t.Parallel()
+
+		messages, trailer := grpcWebCall(t, "application/grpc-web", "/routeguide.RouteGuide/RouteChat", &routeguide.RouteNote{})
+		assert.Empty(t, messages)
+		assert.Equal(t, "5", trailer.Get("grpc-status"))
+	})
+
+	t.Run("CORS preflight", func(t *testing.T) {
+		t.Parallel()
+
+		req, err := http.NewRequest(http.MethodOptions, serverURL+"/helloworld.Greeter/SayHello", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-user-agent")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "http://localhost:3000", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "content-type,x-grpc-web,x-user-agent", resp.Header.Get("Access-Control-Allow-Headers"))
+	})
+}
+
+func TestConnect(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, method, contentType string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, serverURL+method, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Connect-Protocol-Version", "1")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, respBody
+	}
+
+	t.Run("Unary JSON", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := post(t, "/helloworld.Greeter/SayHello", "application/json", []byte(`{"name": "Jane"}`))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"message": "Hello from proto stub"}`, string(body))
+	})
+
+	t.Run("Unary proto", func(t *testing.T) {
+		t.Parallel()
+
+		data, err := proto.Marshal(&helloworldpb.HelloRequest{Name: "Jane"})
+		require.NoError(t, err)
+		resp, body := post(t, "/helloworld.Greeter/SayHello", "application/proto", data)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var reply helloworldpb.HelloReply
+		require.NoError(t, proto.Unmarshal(body, &reply))
+		assert.Equal(t, "Hello from proto stub", reply.Message)
+	})
+
+	t.Run("Unary GET", func(t *testing.T) {
+		t.Parallel()
+
+		query := url.Values{
+			"connect":  {"v1"},
+			"encoding": {"json"},
+			"message":  {`{"latitude": 1}`},
+		}
+		resp, err := http.Get(serverURL + "/routeguide.RouteGuide/GetFeature?" + query.Encode())
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"name": "stub feature", "location": {"latitude": 13, "longitude": 15}}`, string(body))
+	})
+
+	t.Run("Unknown method", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := post(t, "/helloworld.Greeter/SayGoodbye", "application/json", []byte(`{}`))
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+		assert.JSONEq(t, `{"code": "unimplemented", "message": "unknown method /helloworld.Greeter/SayGoodbye"}`, string(body))
+	})
+
+	t.Run("Server side streaming", func(t *testing.T) {
+		t.Parallel()
+
+		body := binary.BigEndian.AppendUint32([]byte{0}, 2)
+		body = append(body, "{}"...)
+		resp, respBody := post(t, "/routeguide.RouteGuide/ListFeatures", "application/connect+json", body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/connect+json", resp.Header.Get("Content-Type"))
+
+		var envelopes []string
+		flags := make([]byte, 0)
+		for len(respBody) > 0 {
+			require.GreaterOrEqual(t, len(respBody), 5)
+			size := binary.BigEndian.Uint32(respBody[1:5])
+			flags = append(flags, respBody[0])
+			envelopes = append(envelopes, string(respBody[5:5+size]))
+			respBody = respBody[5+size:]
+		}
+		require.Len(t, envelopes, 4)
+		assert.Equal(t, []byte{0, 0, 0, 2}, flags)
+		assert.JSONEq(t, `{"name": "#3", "location": {"latitude": 419999544, "longitude": 733555590}}`, envelopes[2])
+		assert.JSONEq(t, `{}`, envelopes[3])
+	})
+
+	t.Run("Streaming error", func(t *testing.T) {
+		t.Parallel()
+
+		body := binary.BigEndian.AppendUint32([]byte{0}, 2)
+		body = append(body, "{}"...)
+		resp, respBody := post(t, "/routeguide.RouteGuide/RouteChat", "application/connect+json", body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Greater(t, len(respBody), 5)
+		assert.Equal(t, byte(2), respBody[0])
+
+		var end struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(respBody[5:], &end))
+		assert.Equal(t, "not_found", end.Error.Code)
+	})
+}
+
+func TestTranscoding(t *testing.T) {
+	t.Parallel()
+
+	server, err := startTestServer("", "testdata/transcode/protos", "testdata/transcode/stubs")
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	do := func(t *testing.T, method, path string, body string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(respBody)
+	}
+
+	t.Run("Path variables", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := do(t, http.MethodGet, "/v1/shelves/1/books/2", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"name": "shelves/1/books/2", "title": "The Go Programming Language", "author": "Alan Donovan"}`, body)
+	})
+
+	t.Run("Body field", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := do(t, http.MethodPost, "/v1/shelves/1/books", `{"title": "Concurrency in Go"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"name": "shelves/1/books/3", "title": "Concurrency in Go", "author": "Katherine Cox-Buday"}`, body)
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := do(t, http.MethodPost, "/v1/shelves/1/books", `{"pages": 1}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, `"code":3`)
+	})
+
+	t.Run("Invalid query parameter", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := do(t, http.MethodGet, "/v1/shelves/1/books?page_size=ten", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Server side streaming", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := do(t, http.MethodGet, "/v1/shelves/1/books?pageSize=2", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		require.Len(t, lines, 2)
+		assert.JSONEq(t, `{"result": {"name": "shelves/1/books/3", "title": "Concurrency in Go"}}`, lines[1])
+	})
+
+	t.Run("No route", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := do(t, http.MethodDelete, "/v1/shelves/1/books/2", "")
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	})
+}
+
+func TestPlainRPC(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, path, contentType string, body []byte) (*http.Response, []byte) {
+		t.Helper()
+
+		resp, err := http.Post(serverURL+path, contentType, bytes.NewReader(body))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, respBody
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := post(t, "/helloworld.Greeter/SayHello", "application/json", []byte(`{"name": "Jane"}`))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"message": "Hello from proto stub"}`, string(body))
+	})
+
+	t.Run("Protobuf", func(t *testing.T) {
+		t.Parallel()
+
+		data, err := proto.Marshal(&helloworldpb.HelloRequest{Name: "Jane"})
+		require.NoError(t, err)
+		resp, body := post(t, "/helloworld.Greeter/SayHello", "application/x-protobuf", data)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-protobuf", resp.Header.Get("Content-Type"))
+
+		var reply helloworldpb.HelloReply
+		require.NoError(t, proto.Unmarshal(body, &reply))
+		assert.Equal(t, "Hello from proto stub", reply.Message)
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := post(t, "/helloworld.Greeter/SayHello", "application/json", []byte(`{"nme": "Jane"}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, string(body), `"code":3`)
+	})
+
+	t.Run("Streaming method", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := post(t, "/routeguide.RouteGuide/ListFeatures", "application/json", []byte(`{}`))
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	})
+
+	t.Run("Not a gRPC method", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := post(t, "/helloworld", "application/json", []byte(`{}`))
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+}
+
+func TestServiceHandlers(t *testing.T) {
+	t.Parallel()
+
+	s, err := handler.NewServer("../../examples/httpstubs", "../../examples/protos", "../../examples/protostubs")
+	require.NoError(t, err)
+	httpServer := httptest.NewServer(s.Handler(handler.ServiceHTTP))
+	defer httpServer.Close()
+	grpcServer := httptest.NewServer(s.Handler(handler.ServiceGRPC))
+	defer grpcServer.Close()
+
+	get := func(t *testing.T, url string) int {
+		t.Helper()
+
+		resp, err := http.Get(url + "/helloworld")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get(t, httpServer.URL))
+	assert.Equal(t, http.StatusNotFound, get(t, grpcServer.URL))
+
+	for url, want := range map[string]bool{grpcServer.URL: true, httpServer.URL: false} {
+		c, err := grpc.NewClient(strings.TrimPrefix(url, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		_, err = helloworldpb.NewGreeterClient(c).SayHello(context.TODO(), &helloworldpb.HelloRequest{Name: "Jane"})
+		assert.Equal(t, want, err == nil, url)
+		require.NoError(t, c.Close())
+	}
+}
+
+func TestAdminWithDescriptorSet(t *testing.T) {
+	t.Parallel()
+
+	set, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(helloworldpb.File_examples_helloworld_helloworld_helloworld_proto),
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "hello.binpb")
+	require.NoError(t, os.WriteFile(path, set, 0o600))
+
+	// Without stub and proto directories, the admin API serves the services
+	// of the descriptor set.
+	h, err := handler.New("", "", "", handler.WithAdmin(), handler.WithGRPCOptions(grpcstub.WithDescriptorSets(path)))
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	stub := `{"service": "helloworld.Greeter", "method": "SayHello", "output": {"data": {"message": "Hello from the admin API"}}}`
+	resp, err := http.Post(server.URL+"/__admin/stubs/grpc", "application/json", strings.NewReader(stub))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	c, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer c.Close()
+	reply, err := helloworldpb.NewGreeterClient(c).SayHello(context.TODO(), &helloworldpb.HelloRequest{Name: "Jane"})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from the admin API", reply.Message)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	h, err := handler.New("../../examples/httpstubs", "../../examples/protos", "../../examples/protostubs", handler.WithMetrics())
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/helloworld")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	req, err := http.NewRequest("BREW", server.URL+"/coffee", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	c, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = helloworldpb.NewGreeterClient(c).SayHello(context.TODO(), &helloworldpb.HelloRequest{Name: "Jane"})
+	require.NoError(t, err)
+	chat, err := routeguide.NewRouteGuideClient(c).RouteChat(context.TODO())
+	require.NoError(t, err)
+	require.NoError(t, chat.Send(&routeguide.RouteNote{}))
+	require.NoError(t, chat.CloseSend())
+	_, err = chat.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	resp, err = http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Contains(t, string(body), `stub_server_requests_total{matched="true",method="GET",path="/helloworld",protocol="http",service="",status="200"} 1`)
+	assert.Contains(t, string(body), `stub_server_requests_total{matched="true",method="SayHello",path="",protocol="grpc",service="helloworld.Greeter",status="OK"} 1`)
+	assert.Contains(t, string(body), `stub_server_requests_total{matched="false",method="other",path="",protocol="http",service="",status="404"} 1`)
+	assert.NotContains(t, string(body), "BREW")
+	assert.Contains(t, string(body), `stub_server_requests_total{matched="false",method="RouteChat",path="",protocol="grpc",service="routeguide.RouteGuide",status="NotFound"} 1`)
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	h, err := handler.New("../../examples/httpstubs", "../../examples/protos", "../../examples/protostubs", handler.WithTracing(tp))
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/helloworld", nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	c, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), "traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	_, err = helloworldpb.NewGreeterClient(c).SayHello(ctx, &helloworldpb.HelloRequest{Name: "Jane"})
+	require.NoError(t, err)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+	assert.Equal(t, "GET /helloworld", ended[0].Name())
+	assert.Equal(t, "helloworld.Greeter/SayHello", ended[1].Name())
+	for _, span := range ended {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String())
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+		assert.Contains(t, span.Attributes(), attribute.Bool("stub.matched", true))
+	}
+}
+
+func TestEchoHeaders(t *testing.T) {
+	t.Parallel()
+
+	echo := []string{"traceparent", "x-request-id", "x-b3-*"}
+	h, err := handler.New("../../examples/httpstubs", "../../examples/protos", "../../examples/protostubs",
+		handler.WithHTTPOptions(httpstub.WithEchoHeaders(echo...)),
+		handler.WithGRPCOptions(grpcstub.WithEchoHeaders(echo...)),
+	)
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/helloworld", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("X-B3-Traceid", "80f198ee56343ba864fe8b2a57d3eff7")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "abc", resp.Header.Get("X-Request-Id"))
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", resp.Header.Get("X-B3-Traceid"))
+	assert.Empty(t, resp.Header.Get("Authorization"))
+
+	c, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), "x-request-id", "def", "authorization", "Bearer secret")
+	var header metadata.MD
+	_, err = helloworldpb.NewGreeterClient(c).SayHello(ctx, &helloworldpb.HelloRequest{Name: "Jane"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"def"}, header.Get("x-request-id"))
+	assert.Empty(t, header.Get("authorization"))
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	var out lockedBuffer
+	h, err := handler.New("../../examples/httpstubs", "../../examples/protos", "../../examples/protostubs",
+		handler.WithAccessLog(
+			accesslog.WithLogger(slog.New(slog.NewJSONHandler(&out, nil))),
+			accesslog.WithBodyLimit(10),
+		),
+	)
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/helloworld")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	resp, err = http.Post(server.URL+"/helloworld", "text/plain", strings.NewReader(strings.Repeat("a", 100<<10)))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	c, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = helloworldpb.NewGreeterClient(c).SayHello(context.TODO(), &helloworldpb.HelloRequest{Name: "Jane"})
+	require.NoError(t, err)
+	stream, err := routeguide.NewRouteGuideClient(c).ListFeatures(context.TODO(), &routeguide.Rectangle{})
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	type body struct {
+		Body          string `json:"body"`
+		BodySize      int    `json:"body_size"`
+		BodyTruncated bool   `json:"body_truncated"`
+	}
+	type exchange struct {
+		Method   string `json:"method"`
+		Status   string `json:"status"`
+		Request  body   `json:"request"`
+		Response body   `json:"response"`
+	}
+	// The exchanges are logged after the responses are sent, in any order.
+	got := map[string]exchange{}
+	require.Eventually(t, func() bool {
+		dec := json.NewDecoder(strings.NewReader(out.String()))
+		for {
+			var e exchange
+			if err := dec.Decode(&e); err != nil {
+				return errors.Is(err, io.EOF) && len(got) == 4
+			}
+			got[e.Method] = e
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, exchange{
+		Method:   "GET",
+		Status:   "200",
+		Response: body{Body: `{"message"`, BodySize: 35, BodyTruncated: true},
+	}, got["GET"])
+	assert.Equal(t, "405", got["POST"].Status)
+	assert.Equal(t, body{Body: "aaaaaaaaaa", BodySize: 100 << 10, BodyTruncated: true}, got["POST"].Request)
+	assert.Equal(t, body{Body: `{"name":"J`, BodySize: 15, BodyTruncated: true}, got["SayHello"].Request)
+	assert.Len(t, got["SayHello"].Response.Body, 10)
+	assert.True(t, got["SayHello"].Response.BodyTruncated)
+	assert.Len(t, got["ListFeatures"].Response.Body, 10)
+	assert.Greater(t, got["ListFeatures"].Response.BodySize, 3*len(`{"name":"#1"}`))
+	assert.True(t, got["ListFeatures"].Response.BodyTruncated)
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
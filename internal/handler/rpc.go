@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	jsonContentType     = "application/json"
+	protobufContentType = "application/x-protobuf"
+)
+
+// isPlainRPC reports whether r is a plain HTTP call of a gRPC method: a POST
+// request with a JSON or binary protobuf body.
+func isPlainRPC(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == jsonContentType || mediaType == protobufContentType
+}
+
+// servePlainRPC calls the unary gRPC method in the request path with the
+// request body and writes the response message encoded like the request.
+// Errors are written as google.rpc.Status with the HTTP status code
+// corresponding to their code. It reports false without writing a response if
+// the path doesn't name a gRPC method.
+func servePlainRPC(s *grpcstub.GRPCService, w http.ResponseWriter, r *http.Request) bool {
+	method, ok := s.Method(r.URL.Path)
+	if !ok {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	codec := messageCodec{json: mediaType == jsonContentType, types: s.Types()}
+
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		writePlainRPCError(w, codec, status.New(codes.Unimplemented, "streaming methods require gRPC, gRPC-Web or Connect"))
+		return true
+	}
+
+	data, err := readBody(r.Body)
+	if err != nil {
+		writePlainRPCError(w, codec, status.New(codes.InvalidArgument, err.Error()))
+		return true
+	}
+
+	out, result := callUnary(r, s, method, codec, data, r.Header)
+	for k, v := range result.header {
+		w.Header()[k] = v
+	}
+	if result.status.Code() != codes.OK {
+		writePlainRPCError(w, codec, result.status)
+		return true
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	if _, err := w.Write(out); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write response", slog.String("error", err.Error()))
+	}
+	return true
+}
+
+func writePlainRPCError(w http.ResponseWriter, codec messageCodec, st *status.Status) {
+	if codec.json {
+		writeStatusJSON(w, st, codec.types)
+		return
+	}
+
+	data, err := proto.Marshal(st.Proto())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", protobufContentType)
+	w.WriteHeader(connectCodeOf(st).httpStatus)
+	_, _ = w.Write(data)
+}
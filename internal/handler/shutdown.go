@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// NewHTTPServer returns an http.Server serving the requests of services over
+// HTTP/1, HTTP/2 with TLS and h2c. Unlike with Handler, shutting the returned
+// server down sends GOAWAY to its HTTP/2 clients, including those of h2c
+// connections, so that they stop starting new calls.
+func (s *Server) NewHTTPServer(services Service, tlsConfig *tls.Config) (*http.Server, error) {
+	h2server := &http2.Server{IdleTimeout: time.Second * 60}
+	srv := &http.Server{
+		Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.serve(w, r, services)
+		}), h2server),
+		TLSConfig: tlsConfig,
+	}
+	if err := http2.ConfigureServer(srv, h2server); err != nil {
+		return nil, fmt.Errorf("configure HTTP/2: %w", err)
+	}
+	return srv, nil
+}
+
+// Shutdown waits until all requests in flight, including gRPC streams, are
+// served or ctx is done. It doesn't stop new requests from being served, so
+// shut down the http.Servers serving s first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.inFlight.idle():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for %d requests in flight: %w", s.inFlight.count(), ctx.Err())
+	}
+}
+
+// requests counts the requests in flight. Connections taken over by h2c
+// aren't tracked by http.Server.Shutdown, so the requests served on them are
+// counted here.
+type requests struct {
+	mu sync.Mutex
+	n  int
+	// done is closed when n drops to 0 and replaced when it rises again.
+	done chan struct{}
+}
+
+func (r *requests) add() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n == 0 {
+		r.done = make(chan struct{})
+	}
+	r.n++
+}
+
+func (r *requests) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n--
+	if r.n == 0 {
+		close(r.done)
+	}
+}
+
+func (r *requests) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+// idle returns a channel that is closed once no request is in flight.
+func (r *requests) idle() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n == 0 {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return r.done
+}
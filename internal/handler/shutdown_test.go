@@ -0,0 +1,109 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	routeguide "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/grpc/status"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Drains streams", func(t *testing.T) {
+		t.Parallel()
+
+		s, srv, conn := startShutdownServer(t)
+		stream := listFeatures(t, context.Background(), conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, srv.Shutdown(ctx))
+
+		done := make(chan error, 1)
+		go func() { done <- s.Shutdown(ctx) }()
+
+		var names []string
+		for {
+			feature, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, feature.Name)
+		}
+		assert.Equal(t, []string{"#1", "#2", "#3"}, names)
+		require.NoError(t, <-done)
+
+		_, err := helloworldpb.NewGreeterClient(conn).SayHello(context.Background(), &helloworldpb.HelloRequest{Name: "Jane"})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+
+		s, _, conn := startShutdownServer(t)
+		streamCtx, cancelStream := context.WithCancel(context.Background())
+		defer cancelStream()
+		listFeatures(t, streamCtx, conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := s.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "wait for 1 requests in flight")
+
+		cancelStream()
+		require.NoError(t, s.Shutdown(context.Background()))
+	})
+}
+
+// startShutdownServer serves the example protos on a new listener, delaying
+// each call for long enough to shut the server down while it's in flight.
+func startShutdownServer(t *testing.T) (*handler.Server, *http.Server, *grpc.ClientConn) {
+	t.Helper()
+
+	s, err := handler.NewServer("", "../../examples/protos", "../../examples/protostubs",
+		handler.WithGRPCOptions(grpcstub.WithDelay(500*time.Millisecond)))
+	require.NoError(t, err)
+	srv, err := s.NewHTTPServer(handler.ServiceAll, nil)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return s, srv, conn
+}
+
+// listFeatures starts a ListFeatures stream and waits for the server to
+// receive it.
+func listFeatures(t *testing.T, ctx context.Context, conn *grpc.ClientConn) grpc.ServerStreamingClient[routeguide.Feature] {
+	t.Helper()
+
+	stream, err := routeguide.NewRouteGuideClient(conn).ListFeatures(ctx, &routeguide.Rectangle{
+		Lo: &routeguide.Point{Latitude: 400000000, Longitude: -750000000},
+		Hi: &routeguide.Point{Latitude: 420000000, Longitude: -730000000},
+	})
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	return stream
+}
@@ -0,0 +1,585 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	// wildcardSegment matches a single path segment.
+	wildcardSegment
+	// deepWildcardSegment matches any number of path segments.
+	deepWildcardSegment
+)
+
+type pathSegment struct {
+	kind    segmentKind
+	literal string
+}
+
+// pathVariable binds the path segments from start to end to a request field.
+type pathVariable struct {
+	fieldPath  []string
+	start, end int
+}
+
+// httpRoute is a REST route of a gRPC method, compiled from the path template
+// of its google.api.http annotation.
+type httpRoute struct {
+	rule     grpcstub.HTTPRule
+	segments []pathSegment
+	vars     []pathVariable
+	verb     string
+}
+
+// fieldValue is the value of a request field bound to a path variable.
+type fieldValue struct {
+	fieldPath []string
+	value     string
+}
+
+// newHTTPRoutes compiles the REST routes of the methods of s. Client streaming
+// methods can't be transcoded and are skipped.
+func newHTTPRoutes(s *grpcstub.GRPCService) ([]*httpRoute, error) {
+	routes := make([]*httpRoute, 0)
+	for _, rule := range s.HTTPRules() {
+		if rule.Method.IsStreamingClient() {
+			slog.Warn("Skipping HTTP rule of client streaming method", slog.String("method", string(rule.Method.FullName())))
+			continue
+		}
+
+		route, err := parseHTTPRoute(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", rule.Method.FullName(), err)
+		}
+		slog.Info("registering HTTP route", slog.String("method", rule.HTTPMethod), slog.String("path", rule.Path), slog.String("rpc", string(rule.Method.FullName())))
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseHTTPRoute parses the path template of rule, following the syntax
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	Verb     = ":" LITERAL ;
+func parseHTTPRoute(rule grpcstub.HTTPRule) (*httpRoute, error) {
+	template := rule.Path
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", rule.Path)
+	}
+
+	route := &httpRoute{rule: rule}
+	if i := strings.LastIndexByte(template, ':'); i > strings.LastIndexByte(template, '/') && i > strings.LastIndexByte(template, '}') {
+		route.verb = template[i+1:]
+		template = template[:i]
+	}
+
+	p := &templateParser{template: template, pos: 1, route: route}
+	if err := p.segments(false); err != nil {
+		return nil, fmt.Errorf("path template %q: %w", rule.Path, err)
+	}
+	if p.pos != len(template) {
+		return nil, fmt.Errorf("path template %q: unexpected %q at %d", rule.Path, template[p.pos], p.pos)
+	}
+	return route, nil
+}
+
+type templateParser struct {
+	template string
+	pos      int
+	route    *httpRoute
+}
+
+func (p *templateParser) segments(inVariable bool) error {
+	for {
+		if err := p.segment(inVariable); err != nil {
+			return err
+		}
+		if p.pos >= len(p.template) || p.template[p.pos] != '/' {
+			return nil
+		}
+		p.pos++
+	}
+}
+
+func (p *templateParser) segment(inVariable bool) error {
+	rest := p.template[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "**"):
+		p.route.segments = append(p.route.segments, pathSegment{kind: deepWildcardSegment})
+		p.pos += 2
+	case strings.HasPrefix(rest, "*"):
+		p.route.segments = append(p.route.segments, pathSegment{kind: wildcardSegment})
+		p.pos++
+	case strings.HasPrefix(rest, "{"):
+		if inVariable {
+			return errors.New("nested variable")
+		}
+		return p.variable()
+	default:
+		end := strings.IndexAny(rest, "/{}")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return fmt.Errorf("empty segment at %d", p.pos)
+		}
+		p.route.segments = append(p.route.segments, pathSegment{kind: literalSegment, literal: rest[:end]})
+		p.pos += end
+	}
+	return nil
+}
+
+func (p *templateParser) variable() error {
+	p.pos++
+	end := strings.IndexAny(p.template[p.pos:], "=}")
+	if end <= 0 {
+		return fmt.Errorf("invalid variable at %d", p.pos)
+	}
+	fieldPath := strings.Split(p.template[p.pos:p.pos+end], ".")
+	p.pos += end
+
+	start := len(p.route.segments)
+	if p.template[p.pos] == '=' {
+		p.pos++
+		if err := p.segments(true); err != nil {
+			return err
+		}
+	} else {
+		p.route.segments = append(p.route.segments, pathSegment{kind: wildcardSegment})
+	}
+
+	if p.pos >= len(p.template) || p.template[p.pos] != '}' {
+		return fmt.Errorf("unclosed variable %q", strings.Join(fieldPath, "."))
+	}
+	p.pos++
+
+	p.route.vars = append(p.route.vars, pathVariable{fieldPath: fieldPath, start: start, end: len(p.route.segments)})
+	return nil
+}
+
+// match reports whether r matches the route and returns the values of the
+// path variables.
+func (rt *httpRoute) match(r *http.Request) ([]fieldValue, bool) {
+	if r.Method != rt.rule.HTTPMethod {
+		return nil, false
+	}
+
+	path := r.URL.EscapedPath()
+	if rt.verb != "" {
+		var ok bool
+		if path, ok = strings.CutSuffix(path, ":"+rt.verb); !ok {
+			return nil, false
+		}
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	// bounds[i] is the index of the first part matched by segment i.
+	bounds := make([]int, len(rt.segments)+1)
+	j := 0
+	for i, seg := range rt.segments {
+		bounds[i] = j
+		switch seg.kind {
+		case literalSegment:
+			if j >= len(parts) || parts[j] != seg.literal {
+				return nil, false
+			}
+			j++
+		case wildcardSegment:
+			if j >= len(parts) || parts[j] == "" {
+				return nil, false
+			}
+			j++
+		case deepWildcardSegment:
+			n := len(parts) - j - (len(rt.segments) - i - 1)
+			if n < 0 {
+				return nil, false
+			}
+			j += n
+		}
+	}
+	if j != len(parts) {
+		return nil, false
+	}
+	bounds[len(rt.segments)] = j
+
+	values := make([]fieldValue, 0, len(rt.vars))
+	for _, v := range rt.vars {
+		matched := parts[bounds[v.start]:bounds[v.end]]
+		unescaped := make([]string, len(matched))
+		for i, part := range matched {
+			s, err := unescapeSegment(part)
+			if err != nil {
+				return nil, false
+			}
+			unescaped[i] = s
+		}
+		values = append(values, fieldValue{fieldPath: v.fieldPath, value: strings.Join(unescaped, "/")})
+	}
+	return values, true
+}
+
+// unescapeSegment decodes the path segment s except for encoded slashes, which
+// are kept as they are, so that a variable value containing "%2F" isn't
+// mistaken for several segments.
+func unescapeSegment(s string) (string, error) {
+	var b strings.Builder
+	start := 0
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] != '%' || s[i+1] != '2' || (s[i+2] != 'F' && s[i+2] != 'f') {
+			continue
+		}
+		part, err := url.PathUnescape(s[start:i])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(part)
+		b.WriteString(s[i : i+3])
+		start = i + 3
+		i += 2
+	}
+	part, err := url.PathUnescape(s[start:])
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(part)
+	return b.String(), nil
+}
+
+// matchHTTPRoute returns the first of routes matching r.
+func matchHTTPRoute(routes []*httpRoute, r *http.Request) (*httpRoute, []fieldValue, bool) {
+	for _, route := range routes {
+		if values, ok := route.match(r); ok {
+			return route, values, true
+		}
+	}
+	return nil, nil, false
+}
+
+// serveTranscoded builds the request message of the method of route from the
+// body, query parameters and path variables of r, calls the method and writes
+// the response as JSON. Server streaming responses are written as newline
+// delimited JSON objects holding either a "result" or an "error".
+func serveTranscoded(s *grpcstub.GRPCService, route *httpRoute, values []fieldValue, w http.ResponseWriter, r *http.Request) {
+	method := route.rule.Method
+	types := s.Types()
+
+	msg, err := route.request(r, values, types)
+	if err != nil {
+		writeStatusJSON(w, status.New(codes.InvalidArgument, err.Error()), types)
+		return
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		writeStatusJSON(w, status.New(codes.Internal, err.Error()), types)
+		return
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+	streaming := method.IsStreamingServer()
+	wroteHeader := false
+	writeHeader := func(header http.Header) {
+		wroteHeader = true
+		h := w.Header()
+		for k, v := range header {
+			h[k] = v
+		}
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var reply []byte
+	result, err := callGRPC(r.Context(), s, fullMethod, r.Header, [][]byte{data},
+		func(header http.Header, msg []byte) error {
+			if !streaming {
+				reply = msg
+				return nil
+			}
+
+			out, err := route.response(msg, types)
+			if err != nil {
+				return err
+			}
+			if !wroteHeader {
+				writeHeader(header)
+			}
+			if _, err := fmt.Fprintf(w, "{\"result\":%s}\n", out); err != nil {
+				return fmt.Errorf("write message: %w", err)
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to serve transcoded request", slog.String("error", err.Error()))
+		result = &grpcResult{status: status.New(codes.Internal, err.Error())}
+	}
+
+	if wroteHeader {
+		if result.status.Code() != codes.OK {
+			_, _ = fmt.Fprintf(w, "{\"error\":%s}\n", statusJSON(result.status, types))
+		}
+		return
+	}
+
+	for k, v := range result.header {
+		w.Header()[k] = v
+	}
+	if result.status.Code() != codes.OK {
+		writeStatusJSON(w, result.status, types)
+		return
+	}
+	if streaming {
+		writeHeader(result.header)
+		return
+	}
+
+	out, err := route.response(reply, types)
+	if err != nil {
+		writeStatusJSON(w, status.New(codes.Internal, err.Error()), types)
+		return
+	}
+	writeHeader(result.header)
+	if _, err := w.Write(out); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write transcoded response", slog.String("error", err.Error()))
+	}
+}
+
+// request builds the request message from the body, the query parameters and
+// the path variables of r, in increasing precedence.
+func (rt *httpRoute) request(r *http.Request, values []fieldValue, types *protoregistry.Types) (proto.Message, error) {
+	md := rt.rule.Method.Input()
+	msg := dynamicpb.NewMessage(md)
+	unmarshal := protojson.UnmarshalOptions{Resolver: types}
+
+	if rt.rule.Body != "" {
+		body, err := readBody(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if rt.rule.Body == "*" {
+				if err := unmarshal.Unmarshal(body, msg); err != nil {
+					return nil, fmt.Errorf("unmarshal body: %w", err)
+				}
+			} else {
+				// Wrap the body to let protojson handle any field type.
+				wrapped := fmt.Appendf(nil, "{%q:%s}", rt.rule.Body, body)
+				if err := unmarshal.Unmarshal(wrapped, msg); err != nil {
+					return nil, fmt.Errorf("unmarshal body into %q: %w", rt.rule.Body, err)
+				}
+			}
+		}
+	}
+
+	if rt.rule.Body != "*" {
+		for key, vals := range r.URL.Query() {
+			fieldPath := strings.Split(key, ".")
+			if rt.rule.Body != "" && fieldPath[0] == rt.rule.Body {
+				continue
+			}
+			if err := setField(msg, fieldPath, vals, types); err != nil {
+				if errors.Is(err, errUnknownField) {
+					continue
+				}
+				return nil, fmt.Errorf("query parameter %q: %w", key, err)
+			}
+		}
+	}
+
+	for _, v := range values {
+		if err := setField(msg, v.fieldPath, []string{v.value}, types); err != nil {
+			return nil, fmt.Errorf("path variable %q: %w", strings.Join(v.fieldPath, "."), err)
+		}
+	}
+
+	return msg, nil
+}
+
+// response converts a binary response message to JSON, selecting the
+// response body field if the rule has one.
+func (rt *httpRoute) response(data []byte, types *protoregistry.Types) ([]byte, error) {
+	md := rt.rule.Method.Output()
+	msg := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", md.FullName(), err)
+	}
+
+	marshal := protojson.MarshalOptions{Resolver: types}
+	if rt.rule.ResponseBody == "" {
+		return marshal.Marshal(msg)
+	}
+
+	fd := findField(md, rt.rule.ResponseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("unknown response body field %q", rt.rule.ResponseBody)
+	}
+	if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+		return marshal.Marshal(msg.Get(fd).Message().Interface())
+	}
+
+	// Marshal the field on its own to get its JSON representation.
+	field := dynamicpb.NewMessage(md)
+	field.Set(fd, msg.Get(fd))
+	marshal.EmitUnpopulated = true
+	out, err := marshal.Marshal(field)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(out, &fields); err != nil {
+		return nil, err
+	}
+	return fields[fd.JSONName()], nil
+}
+
+var errUnknownField = errors.New("unknown field")
+
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+// setField sets the field at fieldPath in msg to the values parsed from their
+// string representation.
+func setField(msg protoreflect.Message, fieldPath []string, values []string, types *protoregistry.Types) error {
+	for i, name := range fieldPath {
+		fd := findField(msg.Descriptor(), name)
+		if fd == nil {
+			return fmt.Errorf("%w %q", errUnknownField, name)
+		}
+
+		if i < len(fieldPath)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %q is not a message", name)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+
+		if fd.IsMap() {
+			return fmt.Errorf("map field %q can't be set", name)
+		}
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+			for _, s := range values {
+				v, err := parseFieldValue(fd, s, types)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+
+		v, err := parseFieldValue(fd, values[0], types)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+// parseFieldValue parses the string representation of a value of fd.
+func parseFieldValue(fd protoreflect.FieldDescriptor, s string, types *protoregistry.Types) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < math.MinInt32 || n > math.MaxInt32 {
+			return protoreflect.Value{}, fmt.Errorf("invalid value %q for enum %s", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// Well-known types like Timestamp or wrappers have a JSON string or
+		// number representation.
+		msg := dynamicpb.NewMessage(fd.Message())
+		unmarshal := protojson.UnmarshalOptions{Resolver: types}
+		if err := unmarshal.Unmarshal([]byte(strconv.Quote(s)), msg); err != nil {
+			if err := unmarshal.Unmarshal([]byte(s), msg); err != nil {
+				return protoreflect.Value{}, fmt.Errorf("invalid value %q for %s", s, fd.Message().FullName())
+			}
+		}
+		return protoreflect.ValueOfMessage(msg), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field kind %v", fd.Kind())
+	}
+}
+
+// writeStatusJSON writes st as a JSON encoded google.rpc.Status with the HTTP
+// status code corresponding to its code.
+func writeStatusJSON(w http.ResponseWriter, st *status.Status, types *protoregistry.Types) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(connectCodeOf(st).httpStatus)
+	_, _ = w.Write(statusJSON(st, types))
+}
+
+func statusJSON(st *status.Status, types *protoregistry.Types) []byte {
+	marshal := protojson.MarshalOptions{Resolver: types}
+	data, err := marshal.Marshal(st.Proto())
+	if err != nil {
+		// Details of unknown types can't be marshaled.
+		p := st.Proto()
+		p.Details = nil
+		data, _ = marshal.Marshal(p)
+	}
+	return data
+}
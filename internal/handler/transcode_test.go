@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPRouteMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		template string
+		path     string
+		values   []fieldValue
+		match    bool
+	}{
+		{"/v1/books", "/v1/books", []fieldValue{}, true},
+		{"/v1/books", "/v1/books/1", nil, false},
+		{"/v1/{name}", "/v1/a%2Fb", []fieldValue{{[]string{"name"}, "a%2Fb"}}, true},
+		{"/v1/{name}", "/v1/a%2fb%20c", []fieldValue{{[]string{"name"}, "a%2fb c"}}, true},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", []fieldValue{{[]string{"name"}, "shelves/1/books/2"}}, true},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/notes/2", nil, false},
+		{"/v1/{book.name=**}", "/v1/a/b/c", []fieldValue{{[]string{"book", "name"}, "a/b/c"}}, true},
+		{"/v1/{name=**}", "/v1/a/b%2Fc%3F", []fieldValue{{[]string{"name"}, "a/b%2Fc?"}}, true},
+		{"/v1/{name=**}/meta", "/v1/a/b/meta", []fieldValue{{[]string{"name"}, "a/b"}}, true},
+		{"/v1/{name}:publish", "/v1/1:publish", []fieldValue{{[]string{"name"}, "1"}}, true},
+		{"/v1/{name}:publish", "/v1/1", nil, false},
+		{"/v1/*/{id}", "/v1/x/1", []fieldValue{{[]string{"id"}, "1"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			route, err := parseHTTPRoute(grpcstub.HTTPRule{HTTPMethod: http.MethodGet, Path: tt.template})
+			require.NoError(t, err)
+
+			values, ok := route.match(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.match, ok)
+			assert.Equal(t, tt.values, values)
+		})
+	}
+
+	for _, template := range []string{"v1/books", "/v1/{name", "/v1/{a={b}}", "/v1//books"} {
+		_, err := parseHTTPRoute(grpcstub.HTTPRule{HTTPMethod: http.MethodGet, Path: template})
+		assert.Error(t, err, template)
+	}
+}
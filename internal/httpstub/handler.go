@@ -6,31 +6,232 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kogxi/stub-server/internal/accesslog"
+	"github.com/kogxi/stub-server/internal/journal"
+	"github.com/kogxi/stub-server/internal/metrics"
+	"github.com/kogxi/stub-server/internal/stubfile"
+	"github.com/kogxi/stub-server/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Handler is an HTTP handler that serves predefined HTTP stubs.
 type Handler struct {
 	stubs *Storage
+	// loaded holds the stubs loaded from the stub directory, which are
+	// restored by Reset.
+	loaded  []Stub
+	journal *journal.Journal
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
+	echo    tracing.Echo
+	// accessLog logs every request with its response.
+	accessLog *accesslog.Logger
+
+	delay           time.Duration
+	unmatchedStatus int
 }
 
 var _ http.Handler = &Handler{}
 
-// NewHandler creates a new Handler by loading HTTP stubs from the specified directory.
-func NewHandler(stubDir string) (*Handler, error) {
-	storage := NewStorage()
-	if err := loadStubs(stubDir, storage); err != nil {
-		return nil, fmt.Errorf("load HTTP stubs from %v: %w ", stubDir, err)
+// Option configures a Handler created by NewHandler.
+type Option func(*Handler)
+
+// WithJournal records every request served by the handler in j.
+func WithJournal(j *journal.Journal) Option {
+	return func(h *Handler) {
+		h.journal = j
+	}
+}
+
+// WithMetrics records the metrics of every request served by the handler in
+// m.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(h *Handler) {
+		h.metrics = m
+	}
+}
+
+// WithAccessLog logs every request served by the handler and its response
+// with l.
+func WithAccessLog(l *accesslog.Logger) Option {
+	return func(h *Handler) {
+		h.accessLog = l
+	}
+}
+
+// WithTracerProvider creates a span with the tracers of tp for every request
+// served by the handler, continuing the trace of the caller propagated in the
+// traceparent header.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(h *Handler) {
+		h.tracer = tracing.Tracer(tp)
+	}
+}
+
+// WithEchoHeaders copies the request headers matching patterns, e.g.
+// "traceparent" or "x-b3-*", into every response. Headers of the stub take
+// precedence. See tracing.Echo for the patterns.
+func WithEchoHeaders(patterns ...string) Option {
+	return func(h *Handler) {
+		h.echo = append(h.echo, patterns...)
+	}
+}
+
+// WithDelay delays every response of a matched stub by d.
+func WithDelay(d time.Duration) Option {
+	return func(h *Handler) {
+		h.delay = d
+	}
+}
+
+// WithUnmatchedStatus sets the status code returned for requests no stub
+// matches. It defaults to 404 Not Found.
+func WithUnmatchedStatus(code int) Option {
+	return func(h *Handler) {
+		h.unmatchedStatus = code
 	}
+}
+
+// NewHandler creates a new Handler by loading HTTP stubs from the specified
+// directory. If stubDir is empty, the handler starts without stubs.
+func NewHandler(stubDir string, opts ...Option) (*Handler, error) {
+	h := newHandler(opts)
+	if stubDir != "" {
+		stubs, err := load(stubfile.LoadDir[Stub](stubDir))
+		if err != nil {
+			return nil, fmt.Errorf("load HTTP stubs from %v: %w ", stubDir, err)
+		}
+		h.loaded = stubs
+	}
+	h.Reset()
+
+	return h, nil
+}
+
+// NewHandlerFS is like NewHandler but loads the HTTP stubs from fsys, e.g. an
+// embed.FS or a zip archive.
+func NewHandlerFS(fsys fs.FS, opts ...Option) (*Handler, error) {
+	h := newHandler(opts)
+	stubs, err := load(stubfile.LoadFS[Stub](fsys))
+	if err != nil {
+		return nil, fmt.Errorf("load HTTP stubs: %w", err)
+	}
+	h.loaded = stubs
+	h.Reset()
+
+	return h, nil
+}
 
-	return &Handler{
-		stubs: storage,
-	}, nil
+func newHandler(opts []Option) *Handler {
+	h := &Handler{
+		stubs:           NewStorage(),
+		tracer:          tracing.Tracer(nil),
+		unmatchedStatus: http.StatusNotFound,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// AddStub validates stub and adds it, replacing any stub for the same path and
+// method.
+func (s *Handler) AddStub(stub Stub) error {
+	if err := stub.validate(); err != nil {
+		return fmt.Errorf("stub validation: %w", err)
+	}
+	s.stubs.Add(stub)
+	return nil
+}
+
+// RemoveStub removes the stub for path and method and reports whether it
+// existed.
+func (s *Handler) RemoveStub(path string, method string) bool {
+	return s.stubs.Remove(path, method)
+}
+
+// Reset removes all stubs added with AddStub and restores the stubs loaded
+// from the stub directory.
+func (s *Handler) Reset() {
+	s.stubs.Clear()
+	for _, stub := range s.loaded {
+		s.stubs.Add(stub)
+	}
 }
 
 // ServeHTTP serves HTTP requests based on the loaded stubs.
 func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	entry := journal.Entry{
+		Protocol: journal.ProtocolHTTP,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Header:   r.Header,
+	}
+	_, span := s.tracer.Start(tracing.ExtractHTTP(r.Context(), r.Header), r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+	defer span.End()
+	s.echo.Header(w.Header(), r.Header)
+
+	var requestBody []byte
+	var requestSize int64
+	if s.accessLog.Enabled(r.Context()) {
+		rec := &responseRecorder{ResponseWriter: w, limit: s.accessLog.BodyLimit()}
+		w = rec
+		defer func() {
+			s.accessLog.Log(r.Context(), accesslog.Exchange{
+				Protocol:         entry.Protocol,
+				Method:           entry.Method,
+				Path:             entry.Path,
+				Matched:          entry.Matched,
+				Status:           strconv.Itoa(entry.Status),
+				Duration:         time.Since(start),
+				RequestHeader:    r.Header,
+				RequestBody:      requestBody,
+				RequestBodySize:  requestSize,
+				ResponseHeader:   rec.Header(),
+				ResponseBody:     rec.body,
+				ResponseBodySize: rec.size,
+			})
+		}()
+	}
+
+	if r.Body != nil {
+		// The body is only read for the journal and the access log, which
+		// keep a bounded part of it. The rest is drained, so that the
+		// connection can be reused.
+		limit := 0
+		if s.journal != nil {
+			limit = journal.MaxBodySize
+		}
+		if s.accessLog.Enabled(r.Context()) {
+			limit = max(limit, s.accessLog.BodyLimit())
+		}
+		body, size, err := readBody(r.Body, int64(limit))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading body", slog.String("error", err.Error()))
+			entry.Status = http.StatusInternalServerError
+			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+			return
+		}
+		requestBody, requestSize = body, size
+		entry.Body = string(body[:min(len(body), journal.MaxBodySize)])
+		entry.BodyTruncated = size > int64(len(entry.Body))
+	}
+
 	stub, err := s.stubs.Get(r)
 	if err != nil {
 		slog.ErrorContext(r.Context(),
@@ -39,23 +240,34 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			slog.String("method", r.Method),
 			slog.String("error", err.Error()),
 		)
+		code, msg := http.StatusInternalServerError, "unknown stub"
 		if errors.Is(err, ErrStubNotFound) {
-			http.Error(w, "unknown stub", http.StatusNotFound)
-			return
+			code = s.unmatchedStatus
 		}
 		if errors.Is(err, ErrMethodNotAllowed) {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
+			code, msg = http.StatusMethodNotAllowed, "method not allowed"
 		}
-		http.Error(w, "unknown stub", http.StatusInternalServerError)
+		entry.Status = code
+		s.journal.Record(entry)
+		http.Error(w, msg, code)
+		s.observe(entry, "", start, 0)
+		endSpan(span, entry, "")
 		return
 	}
 
-	if r.Body != nil {
-		if _, err := io.Copy(io.Discard, r.Body); err != nil {
-			slog.ErrorContext(r.Context(), "Error reading body", slog.String("error", err.Error()))
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+	entry.Matched = true
+	entry.Status = stub.Status
+	s.journal.Record(entry)
+	defer func() {
+		s.observe(entry, r.URL.Path, start, s.delay)
+		endSpan(span, entry, r.URL.Path)
+	}()
+
+	if s.delay > 0 {
+		select {
+		case <-r.Context().Done():
 			return
+		case <-time.After(s.delay):
 		}
 	}
 
@@ -77,3 +289,82 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// readBody reads the first limit bytes of body and discards the rest. It
+// returns the bytes read and the size of the whole body.
+func readBody(body io.Reader, limit int64) ([]byte, int64, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	rest, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, int64(len(data)) + rest, nil
+}
+
+// responseRecorder records the first limit bytes of the body written to the
+// ResponseWriter and its size for the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	limit int
+	body  []byte
+	size  int64
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	if rest := r.limit - len(r.body); rest > 0 {
+		r.body = append(r.body, b[:min(n, rest)]...)
+	}
+	return n, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// observe records the metrics of the request described by entry, which
+// started at start. path is the path of the matched stub or empty.
+func (s *Handler) observe(entry journal.Entry, path string, start time.Time, delay time.Duration) {
+	s.metrics.Observe(metrics.Call{
+		Protocol: entry.Protocol,
+		Method:   metricMethod(entry.Method),
+		Path:     path,
+		Matched:  entry.Matched,
+		Status:   strconv.Itoa(entry.Status),
+		Duration: time.Since(start),
+		Delay:    delay,
+	})
+}
+
+// metricMethod returns method if it is a standard HTTP method and "other"
+// otherwise, so that clients can't add a label value for every method they
+// send.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// endSpan adds the outcome of the request described by entry to span. path is
+// the path of the matched stub or empty.
+func endSpan(span trace.Span, entry journal.Entry, path string) {
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", entry.Status),
+		tracing.AttrStubMatched.Bool(entry.Matched),
+	)
+	if entry.Matched {
+		name := entry.Method + " " + path
+		span.SetName(name)
+		span.SetAttributes(attribute.String("http.route", path), tracing.AttrStub.String(name))
+	}
+	if entry.Status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(entry.Status))
+	}
+}
@@ -49,3 +49,27 @@ func (p *Storage) Get(req *http.Request) (Response, error) {
 
 	return stub, nil
 }
+
+// Remove removes the stub for the given URL and method and reports whether it
+// existed.
+func (p *Storage) Remove(path string, method string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, ok := p.stubs[path][method]; !ok {
+		return false
+	}
+	delete(p.stubs[path], method)
+	if len(p.stubs[path]) == 0 {
+		delete(p.stubs, path)
+	}
+	return true
+}
+
+// Clear removes all stubs.
+func (p *Storage) Clear() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.stubs = map[string]map[string]Response{}
+}
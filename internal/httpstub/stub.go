@@ -1,13 +1,11 @@
 package httpstub
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"io/fs"
 	"net/http"
-	"os"
-	"path/filepath"
+
+	"github.com/kogxi/stub-server/internal/stubfile"
 )
 
 // Stub represents a predefined HTTP stub.
@@ -36,53 +34,35 @@ func (s *Stub) validate() error {
 	return nil
 }
 
-func loadStubs(dir string, storage *Storage) error {
-	if err := filepath.WalkDir(dir, walk(storage)); err != nil {
-		return fmt.Errorf("read stubs from dir %v: %w", dir, err)
-	}
-	return nil
+// Validate checks all HTTP stubs in dir and returns every problem found.
+func Validate(dir string) error {
+	_, err := load(stubfile.LoadDir[Stub](dir))
+	return err
 }
 
-func walk(storage *Storage) fs.WalkDirFunc {
-	return func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			if filepath.Ext(path) != ".json" {
-				return nil
-			}
-
-			stub, err := loadFile(path)
-			if err != nil {
-				return fmt.Errorf("load stub from %v: %w", path, err)
-			}
-
-			storage.Add(stub)
-		}
-		return nil
-	}
+// ValidateFS is like Validate but checks the HTTP stubs in fsys, e.g. a zip
+// archive.
+func ValidateFS(fsys fs.FS) error {
+	_, err := load(stubfile.LoadFS[Stub](fsys))
+	return err
 }
 
-func loadFile(path string) (s Stub, err error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return Stub{}, fmt.Errorf("open file: %v: %w", path, err)
-	}
-	defer func() {
-		closeErr := f.Close()
-		if closeErr != nil {
-			err = errors.Join(err, fmt.Errorf("close file: %w", closeErr))
-		}
-	}()
+// load returns the stubs of entries, which were loaded with err, if all of
+// them are valid.
+func load(entries []stubfile.Entry[Stub], err error) ([]Stub, error) {
+	errs := []error{err}
 
-	var stub Stub
-	if err := json.NewDecoder(f).Decode(&stub); err != nil {
-		return Stub{}, fmt.Errorf("unmarshal stub %v: %w", path, err)
+	stubs := make([]Stub, 0, len(entries))
+	for _, e := range entries {
+		if err := e.Stub.validate(); err != nil {
+			errs = append(errs, e.Errorf("stub validation: %w", err))
+			continue
+		}
+		stubs = append(stubs, e.Stub)
 	}
 
-	if err = stub.validate(); err != nil {
-		return Stub{}, fmt.Errorf("stub validation %v: %w", path, err)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
-	return stub, nil
+	return stubs, nil
 }
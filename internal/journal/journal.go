@@ -0,0 +1,118 @@
+// Package journal records the requests served by the stub servers, so tests
+// can check which calls were made.
+package journal
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// maxEntries bounds the memory used by a journal of a long running server.
+// The oldest entries are dropped first.
+const maxEntries = 10000
+
+// MaxBodySize is the number of bytes of an HTTP request body, or of all the
+// request messages of a gRPC call, recorded in an entry. The rest is left out.
+const MaxBodySize = 64 << 10
+
+// Protocols of the recorded requests.
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// Entry is a recorded request.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"`
+	// Method is the HTTP method of HTTP requests and the method name of gRPC
+	// calls.
+	Method  string `json:"method"`
+	Path    string `json:"path,omitempty"`
+	Service string `json:"service,omitempty"`
+	// Header holds the HTTP header or the gRPC metadata.
+	Header map[string][]string `json:"header,omitempty"`
+	// Body is the body of HTTP requests, cut after MaxBodySize bytes.
+	Body string `json:"body,omitempty"`
+	// BodyTruncated reports whether Body was cut.
+	BodyTruncated bool `json:"body_truncated,omitempty"`
+	// Messages are the request messages of gRPC calls as JSON. Messages are
+	// left out once their total size exceeds MaxBodySize.
+	Messages []json.RawMessage `json:"messages,omitempty"`
+	// MessagesTruncated reports whether messages were left out.
+	MessagesTruncated bool `json:"messages_truncated,omitempty"`
+	// Matched reports whether a stub was found for the request.
+	Matched bool `json:"matched"`
+	// Status is the HTTP status code or the gRPC status code of the response.
+	Status int `json:"status"`
+}
+
+// Filter selects entries. Empty fields match any value.
+type Filter struct {
+	Protocol string
+	Method   string
+	Path     string
+	Service  string
+}
+
+// Match reports whether e is selected by f.
+func (f Filter) Match(e Entry) bool {
+	return (f.Protocol == "" || f.Protocol == e.Protocol) &&
+		(f.Method == "" || f.Method == e.Method) &&
+		(f.Path == "" || f.Path == e.Path) &&
+		(f.Service == "" || f.Service == e.Service)
+}
+
+// Journal is an in-memory list of recorded requests. A nil Journal records
+// nothing.
+type Journal struct {
+	entries []Entry
+
+	m sync.Mutex
+}
+
+// New creates an empty Journal.
+func New() *Journal {
+	return &Journal{}
+}
+
+// Record adds e to the journal.
+func (j *Journal) Record(e Entry) {
+	if j == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if len(j.entries) >= maxEntries {
+		j.entries = j.entries[1:]
+	}
+	j.entries = append(j.entries, e)
+}
+
+// Entries returns the entries selected by f in the order they were recorded.
+func (j *Journal) Entries(f Filter) []Entry {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	entries := make([]Entry, 0)
+	for _, e := range j.entries {
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Reset removes all entries.
+func (j *Journal) Reset() {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	j.entries = nil
+}
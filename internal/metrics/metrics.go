@@ -0,0 +1,100 @@
+// Package metrics exposes Prometheus metrics of the requests served by the
+// stub servers, so load tests can check which traffic hit which stub.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Call is a request served by one of the stub servers.
+type Call struct {
+	// Protocol is journal.ProtocolHTTP or journal.ProtocolGRPC.
+	Protocol string
+	// Service is the service of gRPC calls.
+	Service string
+	// Method is the HTTP method of HTTP requests, or "other" for methods
+	// outside the standard ones, and the method name of gRPC calls. gRPC
+	// calls only reach the stub server for the methods of the loaded protos.
+	Method string
+	// Path is the path of the stub matching an HTTP request. It is empty for
+	// unmatched requests to bound the number of label values.
+	Path    string
+	Matched bool
+	// Status is the HTTP status code or the name of the gRPC status code.
+	Status string
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+	// Delay is the part of Duration spent waiting for configured delays.
+	Delay time.Duration
+}
+
+// Metrics collects the metrics of the served requests. A nil Metrics
+// collects nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	delay    *prometheus.HistogramVec
+	handler  http.Handler
+}
+
+// stubLabels identify the stub serving a request.
+var stubLabels = []string{"protocol", "service", "method", "path"}
+
+// New returns Metrics registered with a new registry, which also holds the Go
+// runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "stub_server_requests_total",
+			Help: "Requests served by the stub server.",
+		}, append(stubLabels, "matched", "status")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stub_server_request_duration_seconds",
+			Help:    "Time taken to serve a request, including injected latency.",
+			Buckets: prometheus.DefBuckets,
+		}, stubLabels),
+		delay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "stub_server_injected_delay_seconds",
+			Help:    "Latency injected into the responses of matched stubs by configured delays.",
+			Buckets: prometheus.DefBuckets,
+		}, stubLabels),
+	}
+	m.registry.MustRegister(
+		m.requests, m.duration, m.delay,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+	return m
+}
+
+// Observe records c.
+func (m *Metrics) Observe(c Call) {
+	if m == nil {
+		return
+	}
+
+	stub := prometheus.Labels{"protocol": c.Protocol, "service": c.Service, "method": c.Method, "path": c.Path}
+	m.duration.With(stub).Observe(c.Duration.Seconds())
+	if c.Matched {
+		m.delay.With(stub).Observe(c.Delay.Seconds())
+	}
+
+	stub["matched"] = strconv.FormatBool(c.Matched)
+	stub["status"] = c.Status
+	m.requests.With(stub).Inc()
+}
+
+// Handler returns the handler serving the metrics in the Prometheus
+// exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return m.handler
+}
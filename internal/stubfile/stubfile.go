@@ -0,0 +1,330 @@
+// Package stubfile decodes stub definitions from JSON and YAML stub files.
+//
+// A stub file contains either a single stub, a list of stubs, or an object
+// with a "stubs" field holding a list of stubs. YAML files may additionally
+// contain several documents separated by "---", each of which follows the
+// same rules.
+package stubfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry is a stub decoded from a stub file together with its position in
+// that file.
+type Entry[T any] struct {
+	Stub T
+	// Path is the path of the file the stub was read from.
+	Path string
+	// Index is the position of the stub within the file, starting at 0.
+	Index int
+	// Line is the line on which the stub starts.
+	Line int
+}
+
+// Errorf returns an error pointing to the position of the entry.
+func (e Entry[T]) Errorf(format string, args ...any) error {
+	return &Error{Path: e.Path, Line: e.Line, Index: e.Index, Err: fmt.Errorf(format, args...)}
+}
+
+// Error describes a problem at a position in a stub file.
+type Error struct {
+	Path string
+	Line int
+	// Index is the position of the affected stub within the file, or -1 if
+	// the problem concerns the file as a whole.
+	Index int
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: stub %d: %v", e.Path, e.Line, e.Index, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsStubFile reports whether the file at path has the extension of a supported
+// stub file format.
+func IsStubFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadDir decodes all stub files found in dir and its subdirectories. Files
+// that can't be decoded don't stop the walk; their errors are joined and
+// returned together with the entries that could be decoded.
+func LoadDir[T any](dir string) ([]Entry[T], error) {
+	return loadFS[T](os.DirFS(dir), dir)
+}
+
+// LoadFS is like LoadDir but reads the stub files from fsys, e.g. an embed.FS
+// or a zip archive. The paths of the entries are relative to the root of fsys.
+func LoadFS[T any](fsys fs.FS) ([]Entry[T], error) {
+	return loadFS[T](fsys, "")
+}
+
+// loadFS decodes all stub files in fsys. If dir is not empty, fsys is the
+// directory dir and the paths of the entries are prefixed with it.
+func loadFS[T any](fsys fs.FS, dir string) ([]Entry[T], error) {
+	entries := make([]Entry[T], 0)
+	var errs []error
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !IsStubFile(path) {
+			return nil
+		}
+
+		name := path
+		if dir != "" {
+			name = filepath.Join(dir, filepath.FromSlash(path))
+		}
+		fileEntries, err := loadFile[T](fsys, path, name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		entries = append(entries, fileEntries...)
+		return nil
+	})
+	if err != nil {
+		if dir == "" {
+			return nil, fmt.Errorf("read stub files: %w", err)
+		}
+		return nil, fmt.Errorf(`read dir "%v": %w`, dir, err)
+	}
+	return entries, errors.Join(errs...)
+}
+
+// loadFile decodes the file at path in fsys, reporting its position as name.
+func loadFile[T any](fsys fs.FS, path string, name string) (entries []Entry[T], err error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %v: %w", name, err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("close file: %w", closeErr))
+		}
+	}()
+
+	return Decode[T](name, f)
+}
+
+// Decode decodes all stubs contained in r. The format is derived from the
+// extension of path. Stubs that can't be decoded are reported as *Error and
+// don't prevent the remaining stubs from being returned.
+func Decode[T any](path string, r io.Reader) ([]Entry[T], error) {
+	if isYAML(path) {
+		return decodeYAML[T](path, r)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read %v: %w", path, err)
+	}
+
+	return decodeJSON[T](path, data)
+}
+
+func decodeYAML[T any](path string, r io.Reader) ([]Entry[T], error) {
+	entries := make([]Entry[T], 0)
+	var errs []error
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			errs = append(errs, &Error{Path: path, Line: yamlErrorLine(err), Index: -1, Err: err})
+			break
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		for _, node := range yamlEntries(doc.Content[0]) {
+			entry := Entry[T]{Path: path, Index: len(entries) + len(errs), Line: node.Line}
+			if err := fromYAML(node, &entry.Stub); err != nil {
+				errs = append(errs, entry.Errorf("decode YAML: %w", err))
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+// yamlEntries returns the nodes of the stubs held by the root node of a YAML
+// document.
+func yamlEntries(root *yaml.Node) []*yaml.Node {
+	if root.Kind == yaml.SequenceNode {
+		return root.Content
+	}
+	if root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == "stubs" && root.Content[i+1].Kind == yaml.SequenceNode {
+				return root.Content[i+1].Content
+			}
+		}
+	}
+	return []*yaml.Node{root}
+}
+
+// fromYAML decodes a YAML node into v by re-encoding it as JSON, so that the
+// json struct tags and json.RawMessage fields of the stub types apply.
+func fromYAML(node *yaml.Node, v any) error {
+	var raw any
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("convert to JSON: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func yamlErrorLine(err error) int {
+	var line int
+	if _, scanErr := fmt.Sscanf(err.Error(), "yaml: line %d:", &line); scanErr != nil {
+		return 0
+	}
+	return line
+}
+
+func decodeJSON[T any](path string, data []byte) ([]Entry[T], error) {
+	raws, err := splitJSON(data)
+	if err != nil {
+		return nil, &Error{Path: path, Line: jsonErrorLine(data, err), Index: -1, Err: fmt.Errorf("decode JSON: %w", err)}
+	}
+
+	entries := make([]Entry[T], 0, len(raws))
+	var errs []error
+	for i, raw := range raws {
+		entry := Entry[T]{Path: path, Index: i, Line: lineAt(data, raw.offset)}
+		if err := json.Unmarshal(raw.data, &entry.Stub); err != nil {
+			errs = append(errs, entry.Errorf("decode JSON: %w", err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errors.Join(errs...)
+}
+
+type rawEntry struct {
+	data   json.RawMessage
+	offset int64
+}
+
+// splitJSON splits data into its stubs if it is a list of stubs or a
+// {"stubs": [...]} wrapper. Otherwise data holds a single stub.
+func splitJSON(data []byte) ([]rawEntry, error) {
+	start := skipSpace(data, 0)
+	if start == int64(len(data)) {
+		return nil, errors.New("empty stub file")
+	}
+	if !json.Valid(data) {
+		// Decode again to get a descriptive *json.SyntaxError.
+		var v any
+		return nil, json.Unmarshal(data, &v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	switch data[start] {
+	case '[':
+		return splitList(dec, data)
+	case '{':
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if key == "stubs" {
+				if data[skipSpace(data, dec.InputOffset())] != '[' {
+					return nil, errors.New(`"stubs" must be a list`)
+				}
+				return splitList(dec, data)
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return []rawEntry{{data: data, offset: start}}, nil
+}
+
+// splitList reads the elements of the JSON array that dec is positioned at.
+func splitList(dec *json.Decoder, data []byte) ([]rawEntry, error) {
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	raws := make([]rawEntry, 0)
+	for dec.More() {
+		offset := skipSpace(data, dec.InputOffset())
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		raws = append(raws, rawEntry{data: raw, offset: offset})
+	}
+	return raws, nil
+}
+
+// skipSpace returns the offset of the first byte at or after offset that is
+// neither whitespace nor a separator.
+func skipSpace(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func jsonErrorLine(data []byte, err error) int {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return lineAt(data, min(syntaxErr.Offset, int64(len(data))))
+	}
+	return 1
+}
@@ -0,0 +1,108 @@
+package stubfile_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/kogxi/stub-server/internal/stubfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stub struct {
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		path      string
+		data      string
+		wantStubs []stub
+		wantLines []int
+	}{
+		{
+			name:      "JSON single stub",
+			path:      "stub.json",
+			data:      `{"path": "/a", "status": 200}`,
+			wantStubs: []stub{{Path: "/a", Status: 200}},
+			wantLines: []int{1},
+		},
+		{
+			name:      "JSON list",
+			path:      "stub.json",
+			data:      "[\n  {\"path\": \"/a\", \"status\": 200},\n  {\"path\": \"/b\", \"status\": 201}\n]",
+			wantStubs: []stub{{Path: "/a", Status: 200}, {Path: "/b", Status: 201}},
+			wantLines: []int{2, 3},
+		},
+		{
+			name:      "JSON wrapper",
+			path:      "stub.json",
+			data:      "{\n  \"stubs\": [\n    {\"path\": \"/a\", \"status\": 200}\n  ]\n}",
+			wantStubs: []stub{{Path: "/a", Status: 200}},
+			wantLines: []int{3},
+		},
+		{
+			name:      "YAML documents",
+			path:      "stub.yml",
+			data:      "# first\npath: /a\nstatus: 200\n---\n- path: /b\n  status: 201\n---\nstubs:\n  - path: /c\n    status: 202\n",
+			wantStubs: []stub{{Path: "/a", Status: 200}, {Path: "/b", Status: 201}, {Path: "/c", Status: 202}},
+			wantLines: []int{2, 5, 9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries, err := stubfile.Decode[stub](tt.path, strings.NewReader(tt.data))
+			require.NoError(t, err)
+
+			stubs := make([]stub, 0, len(entries))
+			lines := make([]int, 0, len(entries))
+			for _, e := range entries {
+				stubs = append(stubs, e.Stub)
+				lines = append(lines, e.Line)
+			}
+			assert.Equal(t, tt.wantStubs, stubs)
+			assert.Equal(t, tt.wantLines, lines)
+		})
+	}
+
+	t.Run("Invalid entry", func(t *testing.T) {
+		t.Parallel()
+
+		entries, err := stubfile.Decode[stub]("stub.json", strings.NewReader("[\n{\"path\": \"/a\"},\n{\"path\": 1}\n]"))
+		require.Error(t, err)
+		assert.Len(t, entries, 1)
+		assert.Contains(t, err.Error(), "stub.json:3: stub 1: ")
+	})
+}
+
+func TestLoadFS(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"a.json":        {Data: []byte(`{"path": "/a", "status": 200}`)},
+		"nested/b.yaml": {Data: []byte("path: /b\nstatus: 201\n")},
+		"nested/c.json": {Data: []byte(`{"path": 1}`)},
+		"README.md":     {Data: []byte("not a stub")},
+	}
+
+	entries, err := stubfile.LoadFS[stub](fsys)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nested/c.json:1: stub 0: ")
+
+	stubs := make([]stub, 0, len(entries))
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		stubs = append(stubs, e.Stub)
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []stub{{Path: "/a", Status: 200}, {Path: "/b", Status: 201}}, stubs)
+	assert.Equal(t, []string{"a.json", "nested/b.yaml"}, paths)
+}
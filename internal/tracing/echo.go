@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Echo selects the request headers that are copied into the responses, like
+// the trace context and correlation IDs, e.g. "traceparent", "x-request-id"
+// or "x-b3-*". A pattern ending in "*" matches all headers starting with the
+// text before it. Matching ignores case.
+type Echo []string
+
+// Matches reports whether the header name is echoed.
+func (e Echo) Matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range e {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Header adds the echoed headers of the request header src to the response
+// header dst.
+func (e Echo) Header(dst http.Header, src http.Header) {
+	for k, v := range src {
+		if e.Matches(k) {
+			dst[k] = append([]string(nil), v...)
+		}
+	}
+}
+
+// Metadata returns the echoed entries of the request metadata md.
+func (e Echo) Metadata(md metadata.MD) metadata.MD {
+	echoed := metadata.MD{}
+	for k, v := range md {
+		if e.Matches(k) {
+			echoed[k] = append([]string(nil), v...)
+		}
+	}
+	return echoed
+}
@@ -0,0 +1,100 @@
+// Package tracing creates OpenTelemetry spans for the requests served by the
+// stub servers and exports them over OTLP, so distributed traces stay intact
+// when a dependency is stubbed.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/metadata"
+)
+
+// instrumentationName is the name of the tracer creating the spans.
+const instrumentationName = "github.com/kogxi/stub-server"
+
+// Attributes of the spans in addition to the semantic conventions of HTTP and
+// RPC spans.
+const (
+	// AttrStubMatched reports whether a stub matched the request.
+	AttrStubMatched = attribute.Key("stub.matched")
+	// AttrStub names the matched stub, e.g. "GET /hello" or
+	// "helloworld.Greeter/SayHello".
+	AttrStub = attribute.Key("stub.name")
+)
+
+// propagator extracts the W3C trace context and baggage of incoming requests.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Tracer returns the tracer of tp to create the spans of served requests, or
+// a tracer creating no spans if tp is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// ExtractHTTP returns ctx with the trace context of header, so that spans
+// started with it continue the trace of the caller.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractGRPC is like ExtractHTTP for the metadata of a gRPC call.
+func ExtractGRPC(ctx context.Context, md metadata.MD) context.Context {
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// NewProvider returns a tracer provider exporting the spans in batches over
+// OTLP/gRPC to the collector at endpoint, a URL like "http://localhost:4317".
+// The "http" scheme selects a plaintext connection, "https" TLS. The spans are
+// attributed to the service serviceName. Shut the provider down to flush the
+// remaining spans.
+func NewProvider(ctx context.Context, endpoint string, serviceName string) (*sdktrace.TracerProvider, error) {
+	if !strings.Contains(endpoint, "://") {
+		return nil, fmt.Errorf(`OTLP endpoint %q must be a URL like "http://localhost:4317"`, endpoint)
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", serviceName))
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
@@ -0,0 +1,223 @@
+// Package stubclient controls a running stub server through its admin API,
+// which is enabled with the --admin flag. Tests use it to register stubs at
+// runtime instead of writing stub files before startup, and to check the
+// requests the server received.
+package stubclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const adminPrefix = "/__admin/"
+
+// Protocols of the recorded requests.
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// Client talks to the admin API of a stub server.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// Option configures a Client created by New.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to call the admin API.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// New creates a Client for the stub server at baseURL, e.g.
+// "http://localhost:50051".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Request is a request recorded by the stub server.
+type Request struct {
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"`
+	// Method is the HTTP method of HTTP requests and the method name of gRPC
+	// calls.
+	Method  string `json:"method"`
+	Path    string `json:"path,omitempty"`
+	Service string `json:"service,omitempty"`
+	// Header holds the HTTP header or the gRPC metadata.
+	Header map[string][]string `json:"header,omitempty"`
+	// Body is the body of HTTP requests, cut after 64 KiB.
+	Body string `json:"body,omitempty"`
+	// BodyTruncated reports whether Body was cut.
+	BodyTruncated bool `json:"body_truncated,omitempty"`
+	// Messages are the request messages of gRPC calls as JSON.
+	Messages []json.RawMessage `json:"messages,omitempty"`
+	// MessagesTruncated reports whether messages were left out of Messages.
+	MessagesTruncated bool `json:"messages_truncated,omitempty"`
+	// Matched reports whether a stub was found for the request.
+	Matched bool `json:"matched"`
+	// Status is the HTTP status code or the gRPC status code of the response.
+	Status int `json:"status"`
+}
+
+// Filter selects recorded requests. Empty fields match any value.
+type Filter struct {
+	Protocol string
+	Method   string
+	Path     string
+	Service  string
+}
+
+// HTTPRequests selects the HTTP requests with the given method and path.
+func HTTPRequests(method string, path string) Filter {
+	return Filter{Protocol: ProtocolHTTP, Method: method, Path: path}
+}
+
+// GRPCCalls selects the calls of the method of the service with the given
+// full name.
+func GRPCCalls(service string, method string) Filter {
+	return Filter{Protocol: ProtocolGRPC, Service: service, Method: method}
+}
+
+// APIError is returned if the admin API rejects a request.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("admin API: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// AddHTTPStub adds stub, replacing any stub for the same method and path.
+func (c *Client) AddHTTPStub(ctx context.Context, stub *HTTPStub) error {
+	return c.do(ctx, http.MethodPost, "stubs/http", nil, stub, nil)
+}
+
+// RemoveHTTPStub removes the stub for method and path.
+func (c *Client) RemoveHTTPStub(ctx context.Context, method string, path string) error {
+	return c.do(ctx, http.MethodDelete, "stubs/http", url.Values{"method": {method}, "path": {path}}, nil, nil)
+}
+
+// AddGRPCStub adds stub, replacing any stub for the same service and method.
+// The server checks the stub against its proto definitions.
+func (c *Client) AddGRPCStub(ctx context.Context, stub *GRPCStub) error {
+	if stub.err != nil {
+		return stub.err
+	}
+	return c.do(ctx, http.MethodPost, "stubs/grpc", nil, stub, nil)
+}
+
+// RemoveGRPCStub removes the stub for service and method.
+func (c *Client) RemoveGRPCStub(ctx context.Context, service string, method string) error {
+	return c.do(ctx, http.MethodDelete, "stubs/grpc", url.Values{"service": {service}, "method": {method}}, nil, nil)
+}
+
+// Requests returns the recorded requests selected by f, oldest first.
+func (c *Client) Requests(ctx context.Context, f Filter) ([]Request, error) {
+	query := url.Values{}
+	for k, v := range map[string]string{"protocol": f.Protocol, "method": f.Method, "path": f.Path, "service": f.Service} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+
+	var requests []Request
+	if err := c.do(ctx, http.MethodGet, "journal", query, nil, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Verify returns an error unless exactly count recorded requests are selected
+// by f.
+func (c *Client) Verify(ctx context.Context, f Filter, count int) error {
+	requests, err := c.Requests(ctx, f)
+	if err != nil {
+		return err
+	}
+	if len(requests) != count {
+		return fmt.Errorf("expected %d requests matching %+v, got %d", count, f, len(requests))
+	}
+	return nil
+}
+
+// ClearRequests removes all recorded requests.
+func (c *Client) ClearRequests(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "journal", nil, nil, nil)
+}
+
+// Reset removes all stubs added through the admin API, restores the stubs
+// loaded from files and removes all recorded requests.
+func (c *Client) Reset(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "reset", nil, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, in any, out any) error {
+	u := c.baseURL + adminPrefix + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("call admin API: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &e); err != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: e.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
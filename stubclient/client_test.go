@@ -0,0 +1,148 @@
+package stubclient_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/kogxi/stub-server/stubclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+	routeguide "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/grpc/status"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	h, err := handler.New("", "../examples/protos", "", handler.WithAdmin())
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	client := stubclient.New(server.URL)
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, conn.Close())
+	})
+	greeter := helloworldpb.NewGreeterClient(conn)
+
+	t.Run("HTTP stub", func(t *testing.T) {
+		stub := stubclient.NewHTTPStub(http.MethodGet, "/users/1").
+			WithStatus(http.StatusOK).
+			WithHeader("Content-Type", "application/json").
+			WithBody(map[string]any{"name": "Jane"})
+		require.NoError(t, client.AddHTTPStub(ctx, stub))
+
+		resp, err := http.Get(server.URL + "/users/1")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"name": "Jane"}`, string(body))
+
+		require.NoError(t, client.Verify(ctx, stubclient.HTTPRequests(http.MethodGet, "/users/1"), 1))
+
+		require.NoError(t, client.RemoveHTTPStub(ctx, http.MethodGet, "/users/1"))
+		resp, err = http.Get(server.URL + "/users/1")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		requests, err := client.Requests(ctx, stubclient.HTTPRequests(http.MethodGet, "/users/1"))
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.True(t, requests[0].Matched)
+		assert.False(t, requests[1].Matched)
+		assert.Equal(t, http.StatusNotFound, requests[1].Status)
+	})
+
+	t.Run("gRPC stub", func(t *testing.T) {
+		stub := stubclient.NewGRPCStub("helloworld.Greeter", "SayHello").
+			WithResponse(&helloworldpb.HelloReply{Message: "Hello Jane"})
+		require.NoError(t, client.AddGRPCStub(ctx, stub))
+
+		reply, err := greeter.SayHello(ctx, &helloworldpb.HelloRequest{Name: "Jane"})
+		require.NoError(t, err)
+		assert.Equal(t, "Hello Jane", reply.GetMessage())
+
+		stub = stubclient.NewGRPCStub("helloworld.Greeter", "SayHello").WithError(codes.PermissionDenied, "denied")
+		require.NoError(t, client.AddGRPCStub(ctx, stub))
+		_, err = greeter.SayHello(ctx, &helloworldpb.HelloRequest{Name: "John"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		requests, err := client.Requests(ctx, stubclient.GRPCCalls("helloworld.Greeter", "SayHello"))
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.JSONEq(t, `{"name": "John"}`, string(requests[1].Messages[0]))
+		assert.Equal(t, int(codes.PermissionDenied), requests[1].Status)
+	})
+
+	t.Run("Request bodies", func(t *testing.T) {
+		for _, body := range []string{`{"small": true}`, strings.Repeat("x", 100<<10)} {
+			resp, err := http.Post(server.URL+"/upload", "text/plain", strings.NewReader(body))
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		requests, err := client.Requests(ctx, stubclient.HTTPRequests(http.MethodPost, "/upload"))
+		require.NoError(t, err)
+		require.Len(t, requests, 2)
+		assert.Equal(t, `{"small": true}`, requests[0].Body)
+		assert.False(t, requests[0].BodyTruncated)
+		assert.Len(t, requests[1].Body, 64<<10)
+		assert.True(t, requests[1].BodyTruncated)
+	})
+
+	t.Run("Request messages", func(t *testing.T) {
+		require.NoError(t, client.AddGRPCStub(ctx, stubclient.NewGRPCStub("routeguide.RouteGuide", "RecordRoute").WithJSONResponse(`{}`)))
+
+		stream, err := routeguide.NewRouteGuideClient(conn).RecordRoute(ctx)
+		require.NoError(t, err)
+		for i := range 5000 {
+			require.NoError(t, stream.Send(&routeguide.Point{Latitude: int32(i), Longitude: int32(i)}))
+		}
+		_, err = stream.CloseAndRecv()
+		require.NoError(t, err)
+
+		requests, err := client.Requests(ctx, stubclient.GRPCCalls("routeguide.RouteGuide", "RecordRoute"))
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.NotEmpty(t, requests[0].Messages)
+		assert.Less(t, len(requests[0].Messages), 5000)
+		assert.True(t, requests[0].MessagesTruncated)
+	})
+
+	t.Run("Invalid gRPC stub", func(t *testing.T) {
+		stub := stubclient.NewGRPCStub("helloworld.Greeter", "SayHello").WithJSONResponse(`{"msg": "typo"}`)
+		err := client.AddGRPCStub(ctx, stub)
+
+		var apiErr *stubclient.APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		require.NoError(t, client.Reset(ctx))
+
+		_, err := greeter.SayHello(ctx, &helloworldpb.HelloRequest{Name: "Jane"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		requests, err := client.Requests(ctx, stubclient.Filter{Protocol: stubclient.ProtocolHTTP})
+		require.NoError(t, err)
+		assert.Empty(t, requests)
+	})
+}
@@ -0,0 +1,148 @@
+package stubclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// HTTPStub is a stub for an HTTP request, built with NewHTTPStub.
+type HTTPStub struct {
+	Path     string       `json:"path"`
+	Method   string       `json:"method"`
+	Response HTTPResponse `json:"response"`
+}
+
+// HTTPResponse is the response returned by an HTTPStub.
+type HTTPResponse struct {
+	Header http.Header    `json:"header,omitempty"`
+	Body   map[string]any `json:"body,omitempty"`
+	Status int            `json:"status"`
+}
+
+// NewHTTPStub creates a stub answering requests with the given method and
+// path with an empty 200 response.
+func NewHTTPStub(method string, path string) *HTTPStub {
+	return &HTTPStub{
+		Path:     path,
+		Method:   method,
+		Response: HTTPResponse{Status: http.StatusOK},
+	}
+}
+
+// WithStatus sets the status code of the response.
+func (s *HTTPStub) WithStatus(status int) *HTTPStub {
+	s.Response.Status = status
+	return s
+}
+
+// WithHeader adds a header to the response.
+func (s *HTTPStub) WithHeader(key string, value string) *HTTPStub {
+	if s.Response.Header == nil {
+		s.Response.Header = http.Header{}
+	}
+	s.Response.Header.Add(key, value)
+	return s
+}
+
+// WithBody sets the JSON body of the response.
+func (s *HTTPStub) WithBody(body map[string]any) *HTTPStub {
+	s.Response.Body = body
+	return s
+}
+
+// GRPCStub is a stub for a gRPC method, built with NewGRPCStub.
+type GRPCStub struct {
+	Service string     `json:"service"`
+	Method  string     `json:"method"`
+	Output  GRPCOutput `json:"output"`
+
+	// err holds the first error of the builder methods, which is returned
+	// when the stub is added.
+	err error
+}
+
+// GRPCOutput is the output returned by a GRPCStub.
+type GRPCOutput struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   *codes.Code     `json:"code,omitempty"`
+	Stream *GRPCStream     `json:"stream,omitempty"`
+}
+
+// GRPCStream is the stream of messages returned by a GRPCStub of a server
+// streaming method.
+type GRPCStream struct {
+	Data  []json.RawMessage `json:"data"`
+	Delay int               `json:"delay,omitempty"`
+}
+
+// NewGRPCStub creates a stub for the method of the service with the given
+// full name, e.g. NewGRPCStub("helloworld.Greeter", "SayHello").
+func NewGRPCStub(service string, method string) *GRPCStub {
+	return &GRPCStub{Service: service, Method: method}
+}
+
+// WithResponse sets the response message of a unary or client streaming
+// method.
+func (s *GRPCStub) WithResponse(msg proto.Message) *GRPCStub {
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		s.setErr(fmt.Errorf("marshal response: %w", err))
+		return s
+	}
+	s.Output.Data = data
+	return s
+}
+
+// WithJSONResponse sets the response message of a unary or client streaming
+// method in the JSON format of protobuf.
+func (s *GRPCStub) WithJSONResponse(data string) *GRPCStub {
+	s.Output.Data = json.RawMessage(data)
+	return s
+}
+
+// WithError makes a unary or client streaming method fail with the given
+// status.
+func (s *GRPCStub) WithError(code codes.Code, msg string) *GRPCStub {
+	s.Output.Code = &code
+	s.Output.Error = msg
+	return s
+}
+
+// WithStream sets the messages sent by a server streaming method.
+func (s *GRPCStub) WithStream(msgs ...proto.Message) *GRPCStub {
+	if s.Output.Stream == nil {
+		s.Output.Stream = &GRPCStream{}
+	}
+	for _, msg := range msgs {
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			s.setErr(fmt.Errorf("marshal stream message: %w", err))
+			return s
+		}
+		s.Output.Stream.Data = append(s.Output.Stream.Data, data)
+	}
+	return s
+}
+
+// WithStreamDelay sets the delay between the messages of a server streaming
+// method.
+func (s *GRPCStub) WithStreamDelay(d time.Duration) *GRPCStub {
+	if s.Output.Stream == nil {
+		s.Output.Stream = &GRPCStream{}
+	}
+	s.Output.Stream.Delay = int(d.Milliseconds())
+	return s
+}
+
+func (s *GRPCStub) setErr(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
@@ -0,0 +1,236 @@
+// Package stubserver runs the stub server in-process for Go tests. The server
+// serves HTTP stubs and gRPC stubs on one listener and is stopped when the
+// test finishes:
+//
+//	srv := stubserver.Start(t,
+//		stubserver.WithProtoDir("testdata/protos"),
+//		stubserver.WithGRPCStubs(stubclient.NewGRPCStub("helloworld.Greeter", "SayHello").
+//			WithResponse(&helloworldpb.HelloReply{Message: "Hello"})),
+//	)
+//	client := helloworldpb.NewGreeterClient(srv.GRPCConn())
+package stubserver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kogxi/stub-server/internal/grpcstub"
+	"github.com/kogxi/stub-server/internal/handler"
+	"github.com/kogxi/stub-server/stubclient"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufconnSize is the buffer size of in-memory connections.
+const bufconnSize = 1 << 20
+
+// Option configures a Server started by Start.
+type Option func(*config)
+
+type config struct {
+	httpStubDir string
+	httpStubFS  fs.FS
+	protoDir    string
+	protoFS     fs.FS
+	grpcStubDir string
+	grpcStubFS  fs.FS
+	importPaths []string
+
+	httpStubs []*stubclient.HTTPStub
+	grpcStubs []*stubclient.GRPCStub
+
+	bufconn bool
+}
+
+// WithHTTPStubDir loads the HTTP stub files in dir.
+func WithHTTPStubDir(dir string) Option {
+	return func(c *config) {
+		c.httpStubDir = dir
+	}
+}
+
+// WithHTTPStubFS loads the HTTP stub files in fsys, e.g. an embed.FS.
+func WithHTTPStubFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.httpStubFS = fsys
+	}
+}
+
+// WithProtoDir compiles the .proto files in dir.
+func WithProtoDir(dir string) Option {
+	return func(c *config) {
+		c.protoDir = dir
+	}
+}
+
+// WithProtoFS compiles the .proto files in fsys.
+func WithProtoFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.protoFS = fsys
+	}
+}
+
+// WithImportPaths adds directories in which imports of the proto files are
+// looked up.
+func WithImportPaths(dirs ...string) Option {
+	return func(c *config) {
+		c.importPaths = append(c.importPaths, dirs...)
+	}
+}
+
+// WithGRPCStubDir loads the gRPC stub files in dir.
+func WithGRPCStubDir(dir string) Option {
+	return func(c *config) {
+		c.grpcStubDir = dir
+	}
+}
+
+// WithGRPCStubFS loads the gRPC stub files in fsys.
+func WithGRPCStubFS(fsys fs.FS) Option {
+	return func(c *config) {
+		c.grpcStubFS = fsys
+	}
+}
+
+// WithHTTPStubs adds HTTP stubs. Like the stubs added with Server.Client, they
+// are removed by a reset.
+func WithHTTPStubs(stubs ...*stubclient.HTTPStub) Option {
+	return func(c *config) {
+		c.httpStubs = append(c.httpStubs, stubs...)
+	}
+}
+
+// WithGRPCStubs adds gRPC stubs. Like the stubs added with Server.Client, they
+// are removed by a reset.
+func WithGRPCStubs(stubs ...*stubclient.GRPCStub) Option {
+	return func(c *config) {
+		c.grpcStubs = append(c.grpcStubs, stubs...)
+	}
+}
+
+// WithBufconn serves over an in-memory listener instead of a TCP port. Use
+// Server.HTTPClient and Server.GRPCConn to connect to it.
+func WithBufconn() Option {
+	return func(c *config) {
+		c.bufconn = true
+	}
+}
+
+// Server is a running stub server.
+type Server struct {
+	t      testing.TB
+	url    string
+	dialer func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	httpClient *http.Client
+	client     *stubclient.Client
+}
+
+// Start starts a stub server configured by opts and stops it when the test
+// finishes. It fails the test if the stubs or protos can't be loaded.
+func Start(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	h, err := handler.New(c.httpStubDir, c.protoDir, c.grpcStubDir,
+		handler.WithAdmin(),
+		handler.WithHTTPStubFS(c.httpStubFS),
+		handler.WithProtoFS(c.protoFS),
+		handler.WithGRPCStubFS(c.grpcStubFS),
+		handler.WithGRPCOptions(grpcstub.WithImportPaths(c.importPaths...)))
+	if err != nil {
+		t.Fatalf("stubserver: %v", err)
+	}
+
+	var lis net.Listener
+	s := &Server{t: t}
+	if c.bufconn {
+		bl := bufconn.Listen(bufconnSize)
+		lis = bl
+		s.url = "http://bufconn"
+		s.dialer = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return bl.DialContext(ctx)
+		}
+	} else {
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("stubserver: listen: %v", err)
+		}
+		s.url = "http://" + lis.Addr().String()
+		s.dialer = (&net.Dialer{}).DialContext
+	}
+
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("stubserver: serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	s.httpClient = &http.Client{Transport: &http.Transport{DialContext: s.dialer}}
+	t.Cleanup(s.httpClient.CloseIdleConnections)
+	s.client = stubclient.New(s.url, stubclient.WithHTTPClient(s.httpClient))
+
+	ctx := context.Background()
+	for _, stub := range c.httpStubs {
+		if err := s.client.AddHTTPStub(ctx, stub); err != nil {
+			t.Fatalf("stubserver: add HTTP stub %s %s: %v", stub.Method, stub.Path, err)
+		}
+	}
+	for _, stub := range c.grpcStubs {
+		if err := s.client.AddGRPCStub(ctx, stub); err != nil {
+			t.Fatalf("stubserver: add gRPC stub %s/%s: %v", stub.Service, stub.Method, err)
+		}
+	}
+
+	return s
+}
+
+// URL returns the base URL of the server, e.g. "http://127.0.0.1:43567".
+// With WithBufconn, it can only be used with the client of HTTPClient.
+func (s *Server) URL() string {
+	return s.url
+}
+
+// HTTPClient returns an HTTP client connecting to the server.
+func (s *Server) HTTPClient() *http.Client {
+	return s.httpClient
+}
+
+// Client returns a client of the admin API of the server to add stubs and
+// check the received requests.
+func (s *Server) Client() *stubclient.Client {
+	return s.client
+}
+
+// GRPCConn returns a new gRPC connection to the server, which is closed when
+// the test finishes.
+func (s *Server) GRPCConn() *grpc.ClientConn {
+	s.t.Helper()
+
+	conn, err := grpc.NewClient("passthrough:///"+strings.TrimPrefix(s.url, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return s.dialer(ctx, "tcp", addr)
+		}))
+	if err != nil {
+		s.t.Fatalf("stubserver: dial gRPC: %v", err)
+	}
+	s.t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
@@ -0,0 +1,51 @@
+package stubserver_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/kogxi/stub-server/stubclient"
+	"github.com/kogxi/stub-server/stubserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	helloworldpb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	for name, opts := range map[string][]stubserver.Option{
+		"TCP":     nil,
+		"Bufconn": {stubserver.WithBufconn()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := stubserver.Start(t, append(opts,
+				stubserver.WithProtoDir("../examples/protos"),
+				stubserver.WithGRPCStubs(stubclient.NewGRPCStub("helloworld.Greeter", "SayHello").
+					WithResponse(&helloworldpb.HelloReply{Message: "Hello from Go"})),
+				stubserver.WithHTTPStubFS(fstest.MapFS{
+					"users.yaml": {Data: []byte("path: /users\nmethod: GET\nresponse:\n  status: 200\n  body:\n    name: Jane\n")},
+				}),
+			)...)
+
+			reply, err := helloworldpb.NewGreeterClient(srv.GRPCConn()).SayHello(context.Background(), &helloworldpb.HelloRequest{Name: "Jane"})
+			require.NoError(t, err)
+			assert.Equal(t, "Hello from Go", reply.GetMessage())
+
+			resp, err := srv.HTTPClient().Get(srv.URL() + "/users")
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.JSONEq(t, `{"name": "Jane"}`, string(body))
+
+			require.NoError(t, srv.Client().Verify(context.Background(), stubclient.GRPCCalls("helloworld.Greeter", "SayHello"), 1))
+		})
+	}
+}